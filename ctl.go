@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/brandon-fryslie/long-term/longterm"
+)
+
+// runCtl implements `long-term ctl`, a client for the control socket
+func runCtl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := fs.String("socket", os.Getenv(longterm.SocketEnv), "control socket path (default: $LONG_TERM_SOCKET)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s ctl [--socket PATH] status|set-height N|set-delta ±N|set-width N|set-width-delta ±N|profile NAME|toggle|reset\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Sends a command to a running long-term session.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	rest := fs.Args()
+	if len(rest) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	// Validate locally so bad input never reaches the session
+	var command string
+	switch rest[0] {
+	case "status", "toggle", "reset":
+		if len(rest) != 1 {
+			fs.Usage()
+			return 2
+		}
+		command = rest[0]
+	case "set-height", "set-delta", "set-width", "set-width-delta":
+		if len(rest) != 2 {
+			fs.Usage()
+			return 2
+		}
+		var err error
+		switch rest[0] {
+		case "set-height":
+			_, err = longterm.ParseHeight(rest[1])
+		case "set-width":
+			_, err = longterm.ParseWidth(rest[1])
+		default:
+			_, err = longterm.ParseDelta(rest[1])
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "long-term ctl: %v\n", err)
+			return 2
+		}
+		command = rest[0] + " " + rest[1]
+	case "profile":
+		if len(rest) != 2 {
+			fs.Usage()
+			return 2
+		}
+		command = rest[0] + " " + rest[1]
+	default:
+		fmt.Fprintf(os.Stderr, "long-term ctl: unknown command %q\n", rest[0])
+		return 2
+	}
+
+	if *socket == "" {
+		fmt.Fprintf(os.Stderr, "long-term ctl: no socket given and %s is not set\n", longterm.SocketEnv)
+		return 2
+	}
+
+	client, err := longterm.Dial(*socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "long-term ctl: %v\n", err)
+		return 1
+	}
+	defer client.Close()
+
+	reply, err := client.Do(command)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "long-term ctl: %v\n", err)
+		return 1
+	}
+	if command == "status" {
+		fmt.Println(reply)
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brandon-fryslie/long-term/longterm"
+)
+
+func TestRunCtlValidation(t *testing.T) {
+	t.Setenv(longterm.SocketEnv, "")
+	missing := filepath.Join(t.TempDir(), "none.sock")
+	tests := []struct {
+		args string
+		want int
+	}{
+		{"", 2},
+		{"status extra", 2},
+		{"toggle extra", 2},
+		{"set-height", 2},
+		{"set-height x", 2},
+		{"set-height 0", 2},
+		{"set-height 1 2", 2},
+		{"set-delta 20", 2},
+		{"set-delta +x", 2},
+		{"set-width 0", 2},
+		{"set-width-delta 5", 2},
+		{"profile", 2},
+		{"jump", 2},
+		{"status", 2}, // No socket given or in the environment
+		{"-socket " + missing + " set-delta -5", 1},
+		{"-socket " + missing + " status", 1},
+	}
+	for _, tt := range tests {
+		if got := runCtl(strings.Fields(tt.args)); got != tt.want {
+			t.Errorf("runCtl(%q) = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+}
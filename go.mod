@@ -3,8 +3,9 @@ module github.com/brandon-fryslie/long-term
 go 1.25.5
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/creack/pty v1.1.24
 	golang.org/x/term v0.39.0
 )
 
-require golang.org/x/sys v0.40.0 // indirect
+require golang.org/x/sys v0.40.0
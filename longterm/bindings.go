@@ -0,0 +1,450 @@
+package longterm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// actionKind identifies what a command mode binding does
+type actionKind int
+
+const (
+	actNone         actionKind = iota
+	actHeightBy                // height+=N, height-=N
+	actHeightSet               // height=N
+	actDeltaSet                // delta=±N
+	actWidthBy                 // width+=N, width-=N
+	actWidthSet                // width=N
+	actPromptHeight            // prompt-height
+	actPromptDelta             // prompt-delta
+	actPromptWidth             // prompt-width
+	actToggle                  // toggle
+	actReset                   // reset
+	actProfile                 // profile NAME
+	actNextProfile             // next-profile
+	actSnapshot                // snapshot
+	actUndo                    // undo
+	actRedo                    // redo
+	actRecall                  // recall N
+	actSuspend                 // suspend
+	actExit                    // exit
+)
+
+// simpleActions are the actions that take no argument
+var simpleActions = map[string]actionKind{
+	"none":          actNone,
+	"prompt-height": actPromptHeight,
+	"prompt-delta":  actPromptDelta,
+	"prompt-width":  actPromptWidth,
+	"toggle":        actToggle,
+	"reset":         actReset,
+	"next-profile":  actNextProfile,
+	"snapshot":      actSnapshot,
+	"undo":          actUndo,
+	"redo":          actRedo,
+	"suspend":       actSuspend,
+	"exit":          actExit,
+}
+
+// action is a parsed binding action
+type action struct {
+	kind actionKind
+	n    int    // Amount for size actions, position for recall
+	name string // Profile name
+}
+
+// parseAction parses an action such as "height+=20", "delta=-5",
+// "profile tall" or "toggle"
+func parseAction(spec string) (action, error) {
+	spec = strings.TrimSpace(spec)
+	if kind, ok := simpleActions[spec]; ok {
+		return action{kind: kind}, nil
+	}
+	if name, ok := strings.CutPrefix(spec, "profile "); ok && strings.TrimSpace(name) != "" {
+		return action{kind: actProfile, name: strings.TrimSpace(name)}, nil
+	}
+	if arg, ok := strings.CutPrefix(spec, "recall "); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(arg))
+		if err != nil || n < 1 || n > 9 {
+			return action{}, fmt.Errorf("invalid action %q: recall takes 1-9", spec)
+		}
+		return action{kind: actRecall, n: n}, nil
+	}
+
+	target, op, value := "", "", ""
+	for _, candidate := range []string{"+=", "-=", "="} {
+		if i := strings.Index(spec, candidate); i > 0 {
+			target, op, value = strings.TrimSpace(spec[:i]), candidate, strings.TrimSpace(spec[i+len(candidate):])
+			break
+		}
+	}
+	if op == "" {
+		return action{}, fmt.Errorf("unknown action %q", spec)
+	}
+
+	switch {
+	case (target == "height" || target == "width") && op != "=":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > 9999 {
+			return action{}, fmt.Errorf("invalid action %q: step must be 1-9999", spec)
+		}
+		if op == "-=" {
+			n = -n
+		}
+		if target == "height" {
+			return action{kind: actHeightBy, n: n}, nil
+		}
+		return action{kind: actWidthBy, n: n}, nil
+	case target == "height" && op == "=":
+		n, err := ParseHeight(value)
+		if err != nil {
+			return action{}, fmt.Errorf("invalid action %q: %w", spec, err)
+		}
+		return action{kind: actHeightSet, n: n}, nil
+	case target == "width" && op == "=":
+		n, err := ParseWidth(value)
+		if err != nil {
+			return action{}, fmt.Errorf("invalid action %q: %w", spec, err)
+		}
+		return action{kind: actWidthSet, n: n}, nil
+	case target == "delta" && op == "=":
+		n, err := ParseDelta(value)
+		if err != nil {
+			return action{}, fmt.Errorf("invalid action %q: %w", spec, err)
+		}
+		return action{kind: actDeltaSet, n: n}, nil
+	}
+	return action{}, fmt.Errorf("unknown action %q", spec)
+}
+
+// label is the short description of the action in the overlay help
+func (a action) label() string {
+	switch a.kind {
+	case actHeightBy:
+		return "height " + signed(a.n)
+	case actWidthBy:
+		return "width " + signed(a.n)
+	case actHeightSet:
+		return "height " + strconv.Itoa(a.n)
+	case actWidthSet:
+		return "width " + strconv.Itoa(a.n)
+	case actDeltaSet:
+		return "delta " + signed(a.n)
+	case actPromptHeight:
+		return "height"
+	case actPromptDelta:
+		return "delta"
+	case actPromptWidth:
+		return "width"
+	case actProfile:
+		return "profile " + a.name
+	case actNextProfile:
+		return "next profile"
+	case actRecall:
+		return "recall " + strconv.Itoa(a.n)
+	}
+	for name, kind := range simpleActions {
+		if kind == a.kind {
+			return name
+		}
+	}
+	return ""
+}
+
+// keyAliases are accepted key names besides those KeyEvent.String uses
+var keyAliases = map[string]KeyCode{
+	"escape": KeyESC, "return": KeyEnter, "bs": KeyBackspace,
+	"pgup": KeyPageUp, "pgdn": KeyPageDown, "pagedn": KeyPageDown,
+	"ins": KeyInsert, "del": KeyDelete,
+}
+
+// ParseKey parses a key description in the form KeyEvent.String produces:
+// optional C-, M- (or A-), Meta- and S- prefixes followed by a character
+// or a key name such as Up, PageDown, F5, Space or Esc
+func ParseKey(desc string) (KeyEvent, error) {
+	var ev KeyEvent
+	rest := desc
+	for {
+		if r, ok := cutModifier(rest, "C-"); ok {
+			ev.Ctrl, rest = true, r
+		} else if r, ok := cutModifier(rest, "M-"); ok {
+			ev.Alt, rest = true, r
+		} else if r, ok := cutModifier(rest, "A-"); ok {
+			ev.Alt, rest = true, r
+		} else if r, ok := cutModifier(rest, "Meta-"); ok {
+			ev.Meta, rest = true, r
+		} else if r, ok := cutModifier(rest, "S-"); ok {
+			ev.Shift, rest = true, r
+		} else {
+			break
+		}
+	}
+
+	upper := strings.ToUpper(rest)
+	if utf8.RuneCountInString(rest) == 1 {
+		r, _ := utf8.DecodeRuneInString(rest)
+		if ev.Ctrl && r >= 'A' && r <= 'Z' {
+			r += 'a' - 'A'
+		}
+		ev.Code, ev.Char = KeyChar, r
+	} else if upper == "SPACE" {
+		ev.Code, ev.Char = KeyChar, ' '
+	} else if n, err := strconv.Atoi(strings.TrimPrefix(upper, "F")); err == nil && strings.HasPrefix(upper, "F") && n >= 1 && n <= 12 {
+		ev.Code = KeyF1 + KeyCode(n-1)
+	} else if code, ok := keyAliases[strings.ToLower(rest)]; ok {
+		ev.Code = code
+	} else {
+		for code, name := range keyNames {
+			if strings.EqualFold(rest, name) && code != KeyPaste && code != KeyMouse {
+				ev.Code = code
+				break
+			}
+		}
+	}
+	if ev.Code == KeyUnknown {
+		return KeyEvent{}, fmt.Errorf("unknown key %q", desc)
+	}
+	ev.ShiftCtrl = ev.Shift && ev.Ctrl
+	return ev, nil
+}
+
+// cutModifier removes a modifier prefix, as long as a key name follows
+func cutModifier(desc, prefix string) (string, bool) {
+	rest, ok := strings.CutPrefix(desc, prefix)
+	return rest, ok && rest != ""
+}
+
+// binding maps a key to an action
+type binding struct {
+	key    string // Canonical KeyEvent.String form
+	action action
+}
+
+// defaultBindings reproduce the original hard-wired command mode keys
+var defaultBindings = [][2]string{
+	{"Up", "height+=1"}, {"Down", "height-=1"},
+	{"S-Up", "height+=20"}, {"S-Down", "height-=20"},
+	{"C-Up", "height+=200"}, {"C-Down", "height-=200"},
+	{"Right", "width+=1"}, {"Left", "width-=1"},
+	{"S-Right", "width+=20"}, {"S-Left", "width-=20"},
+	{"C-Right", "width+=200"}, {"C-Left", "width-=200"},
+	{"n", "prompt-height"}, {"d", "prompt-delta"}, {"w", "prompt-width"},
+	{"p", "next-profile"}, {"s", "snapshot"},
+	{"u", "undo"}, {"C-r", "redo"},
+	{"1", "recall 1"}, {"2", "recall 2"}, {"3", "recall 3"},
+	{"Space", "toggle"}, {"r", "reset"}, {"C-z", "suspend"},
+	{"Esc", "exit"}, {"Enter", "exit"},
+}
+
+// bindingTable is the ordered set of command mode bindings
+type bindingTable struct {
+	list  []binding
+	byKey map[string]int
+}
+
+// newBindingTable starts from the defaults and applies overrides, which
+// map key descriptions to actions ("none" unbinds a key)
+func newBindingTable(overrides map[string]string) (*bindingTable, error) {
+	t := &bindingTable{byKey: make(map[string]int)}
+	for _, b := range defaultBindings {
+		if err := t.bind(b[0], b[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := t.bind(key, overrides[key]); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *bindingTable) bind(key, spec string) error {
+	ev, err := ParseKey(key)
+	if err != nil {
+		return fmt.Errorf("binding %q: %w", key, err)
+	}
+	a, err := parseAction(spec)
+	if err != nil {
+		return fmt.Errorf("binding %q: %w", key, err)
+	}
+	b := binding{key: ev.String(), action: a}
+	if i, ok := t.byKey[b.key]; ok {
+		t.list[i] = b
+		return nil
+	}
+	t.byKey[b.key] = len(t.list)
+	t.list = append(t.list, b)
+	return nil
+}
+
+// lookup finds the action for a key. Ctrl+Shift falls back to Ctrl, as
+// many terminals can't tell them apart.
+func (t *bindingTable) lookup(ev KeyEvent) (action, bool) {
+	if i, ok := t.byKey[ev.String()]; ok {
+		return t.list[i].action, true
+	}
+	if ev.Ctrl && ev.Shift {
+		ev.Shift, ev.ShiftCtrl = false, false
+		if i, ok := t.byKey[ev.String()]; ok {
+			return t.list[i].action, true
+		}
+	}
+	return action{}, false
+}
+
+// keyFor returns the first key bound to a, or ""
+func (t *bindingTable) keyFor(a action) string {
+	for _, b := range t.list {
+		if b.action == a {
+			return b.key
+		}
+	}
+	return ""
+}
+
+// helpItem is one "keys: label" entry of the overlay help
+type helpItem struct {
+	keys  []string
+	label string
+
+	// Size steps are folded into "Up/Down: height ±1, S ±20"
+	target string // "height" or "width" for steps
+	step   int
+	extra  []string
+}
+
+func (h helpItem) String() string {
+	s := strings.Join(h.keys, "/") + ": " + h.label
+	if len(h.extra) > 0 {
+		s += ", " + strings.Join(h.extra, ", ")
+	}
+	return s
+}
+
+// help renders the table as overlay lines of at most width columns,
+// leaving out profile and snapshot keys when there are none to use
+func (t *bindingTable) help(hasProfiles, hasSnapshots bool, width int) []string {
+	// One item per distinct action, in table order. Recall keys are shown
+	// with the values they recall instead.
+	var items []*helpItem
+	byAction := make(map[action]*helpItem)
+	for _, b := range t.list {
+		a := b.action
+		if a.kind == actNone || a.kind == actRecall || (!hasProfiles && (a.kind == actNextProfile || a.kind == actProfile)) ||
+			(!hasSnapshots && a.kind == actSnapshot) {
+			continue
+		}
+		if item, ok := byAction[a]; ok {
+			item.keys = append(item.keys, b.key)
+			continue
+		}
+		item := &helpItem{keys: []string{b.key}, label: a.label()}
+		switch a.kind {
+		case actHeightBy:
+			item.target, item.step = "height", a.n
+		case actWidthBy:
+			item.target, item.step = "width", a.n
+		}
+		byAction[a] = item
+		items = append(items, item)
+	}
+
+	// Pair opposite steps: "Up: height +1" and "Down: height -1"
+	var paired []*helpItem
+	used := make(map[*helpItem]bool)
+	for _, item := range items {
+		if used[item] {
+			continue
+		}
+		if item.target != "" {
+			for _, other := range items {
+				if other != item && !used[other] && other.target == item.target && other.step == -item.step {
+					used[other] = true
+					// The increasing keys come first, whichever was bound first
+					up, down := item.keys, other.keys
+					if item.step < 0 {
+						up, down = down, up
+					}
+					n := max(item.step, -item.step)
+					item.keys = append(append([]string(nil), up...), down...)
+					item.label = fmt.Sprintf("%s ±%d", item.target, n)
+					item.step = -n // Mark as paired
+					break
+				}
+			}
+		}
+		paired = append(paired, item)
+	}
+
+	// Fold modifier variants of the same keys into the unmodified item
+	var folded []*helpItem
+	for _, item := range paired {
+		if base, prefix := foldTarget(item, paired); base != nil {
+			base.extra = append(base.extra, fmt.Sprintf("%s ±%d", strings.TrimSuffix(prefix, "-"), -item.step))
+			continue
+		}
+		folded = append(folded, item)
+	}
+
+	// Pack items into lines
+	var lines []string
+	line := ""
+	for _, item := range folded {
+		text := truncate(item.String(), width)
+		switch {
+		case line == "":
+			line = text
+		case utf8.RuneCountInString(line)+2+utf8.RuneCountInString(text) <= width:
+			line += "  " + text
+		default:
+			lines = append(lines, line)
+			line = text
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// foldTarget returns the paired step item whose keys are item's keys
+// without a common modifier prefix, and that prefix
+func foldTarget(item *helpItem, items []*helpItem) (*helpItem, string) {
+	if item.target == "" || item.step >= 0 {
+		return nil, ""
+	}
+	prefix := ""
+	for _, p := range []string{"C-S-", "C-", "M-", "S-"} {
+		if strings.HasPrefix(item.keys[0], p) {
+			prefix = p
+			break
+		}
+	}
+	if prefix == "" {
+		return nil, ""
+	}
+	stripped := make([]string, len(item.keys))
+	for i, key := range item.keys {
+		if !strings.HasPrefix(key, prefix) {
+			return nil, ""
+		}
+		stripped[i] = key[len(prefix):]
+	}
+	for _, other := range items {
+		if other != item && other.target == item.target && other.step < 0 &&
+			strings.Join(other.keys, "/") == strings.Join(stripped, "/") {
+			return other, prefix
+		}
+	}
+	return nil, ""
+}
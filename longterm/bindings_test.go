@@ -0,0 +1,302 @@
+package longterm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		desc    string
+		want    KeyEvent
+		wantErr bool
+	}{
+		{desc: "x", want: KeyEvent{Code: KeyChar, Char: 'x'}},
+		{desc: "X", want: KeyEvent{Code: KeyChar, Char: 'X'}},
+		{desc: "é", want: KeyEvent{Code: KeyChar, Char: 'é'}},
+		{desc: "-", want: KeyEvent{Code: KeyChar, Char: '-'}},
+		{desc: "C-r", want: KeyEvent{Code: KeyChar, Char: 'r', Ctrl: true}},
+		{desc: "C-R", want: KeyEvent{Code: KeyChar, Char: 'r', Ctrl: true}},
+		{desc: "C--", want: KeyEvent{Code: KeyChar, Char: '-', Ctrl: true}},
+		{desc: "M-x", want: KeyEvent{Code: KeyChar, Char: 'x', Alt: true}},
+		{desc: "A-x", want: KeyEvent{Code: KeyChar, Char: 'x', Alt: true}},
+		{desc: "Meta-Up", want: KeyEvent{Code: KeyUp, Meta: true}},
+		{desc: "S-Up", want: KeyEvent{Code: KeyUp, Shift: true}},
+		{desc: "C-S-Down", want: KeyEvent{Code: KeyDown, Ctrl: true, Shift: true, ShiftCtrl: true}},
+		{desc: "S-C-Down", want: KeyEvent{Code: KeyDown, Ctrl: true, Shift: true, ShiftCtrl: true}},
+		{desc: "Space", want: KeyEvent{Code: KeyChar, Char: ' '}},
+		{desc: "space", want: KeyEvent{Code: KeyChar, Char: ' '}},
+		{desc: "F1", want: KeyEvent{Code: KeyF1}},
+		{desc: "f12", want: KeyEvent{Code: KeyF12}},
+		{desc: "Esc", want: KeyEvent{Code: KeyESC}},
+		{desc: "escape", want: KeyEvent{Code: KeyESC}},
+		{desc: "Return", want: KeyEvent{Code: KeyEnter}},
+		{desc: "PgDn", want: KeyEvent{Code: KeyPageDown}},
+		{desc: "pagedown", want: KeyEvent{Code: KeyPageDown}},
+		{desc: "Del", want: KeyEvent{Code: KeyDelete}},
+		{desc: "F13", wantErr: true},
+		{desc: "F0", wantErr: true},
+		{desc: "Paste", wantErr: true},
+		{desc: "Mouse", wantErr: true},
+		{desc: "Hyper-x", wantErr: true},
+		{desc: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseKey(tt.desc)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseKey(%q) error = %v, wantErr %v", tt.desc, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseKey(%q) = %+v, want %+v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestParseKeyRoundTrip(t *testing.T) {
+	for _, desc := range []string{"x", "C-r", "M-x", "C-S-Up", "Meta-Left", "S-F5", "Space", "Esc", "PageDown", "Backspace"} {
+		ev, err := ParseKey(desc)
+		if err != nil {
+			t.Errorf("ParseKey(%q): %v", desc, err)
+			continue
+		}
+		if got := ev.String(); got != desc {
+			t.Errorf("ParseKey(%q).String() = %q", desc, got)
+		}
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    action
+		wantErr bool
+	}{
+		{spec: "toggle", want: action{kind: actToggle}},
+		{spec: "  reset ", want: action{kind: actReset}},
+		{spec: "none", want: action{kind: actNone}},
+		{spec: "height+=20", want: action{kind: actHeightBy, n: 20}},
+		{spec: "height -= 5", want: action{kind: actHeightBy, n: -5}},
+		{spec: "width+=1", want: action{kind: actWidthBy, n: 1}},
+		{spec: "width-=200", want: action{kind: actWidthBy, n: -200}},
+		{spec: "height=500", want: action{kind: actHeightSet, n: 500}},
+		{spec: "width=120", want: action{kind: actWidthSet, n: 120}},
+		{spec: "delta=+20", want: action{kind: actDeltaSet, n: 20}},
+		{spec: "delta=-5", want: action{kind: actDeltaSet, n: -5}},
+		{spec: "profile tall", want: action{kind: actProfile, name: "tall"}},
+		{spec: "recall 3", want: action{kind: actRecall, n: 3}},
+		{spec: "height+=0", wantErr: true},
+		{spec: "height+=10000", wantErr: true},
+		{spec: "height+=x", wantErr: true},
+		{spec: "height=0", wantErr: true},
+		{spec: "delta+=5", wantErr: true},
+		{spec: "depth=5", wantErr: true},
+		{spec: "recall 0", wantErr: true},
+		{spec: "recall 10", wantErr: true},
+		{spec: "profile ", wantErr: true},
+		{spec: "=5", wantErr: true},
+		{spec: "jump", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAction(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAction(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseAction(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestActionLabel(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"height+=20", "height +20"},
+		{"width-=1", "width -1"},
+		{"height=500", "height 500"},
+		{"delta=-5", "delta -5"},
+		{"prompt-delta", "delta"},
+		{"profile tall", "profile tall"},
+		{"next-profile", "next profile"},
+		{"recall 2", "recall 2"},
+		{"suspend", "suspend"},
+	}
+	for _, tt := range tests {
+		a, err := parseAction(tt.spec)
+		if err != nil {
+			t.Fatalf("parseAction(%q): %v", tt.spec, err)
+		}
+		if got := a.label(); got != tt.want {
+			t.Errorf("label of %q = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestBindingTableLookup(t *testing.T) {
+	table, err := newBindingTable(map[string]string{
+		"k":       "height+=5",
+		"Up":      "height+=10",
+		"s":       "none",
+		"C-x":     "reset",
+		"A-q":     "exit",
+		"S-Right": "width=80",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ev   KeyEvent
+		want action
+		ok   bool
+	}{
+		{KeyEvent{Code: KeyChar, Char: 'k'}, action{kind: actHeightBy, n: 5}, true},
+		{KeyEvent{Code: KeyUp}, action{kind: actHeightBy, n: 10}, true},
+		{KeyEvent{Code: KeyDown}, action{kind: actHeightBy, n: -1}, true},
+		{KeyEvent{Code: KeyChar, Char: 's'}, action{kind: actNone}, true},
+		{KeyEvent{Code: KeyChar, Char: 'x', Ctrl: true}, action{kind: actReset}, true},
+		{KeyEvent{Code: KeyChar, Char: 'q', Alt: true}, action{kind: actExit}, true},
+		{KeyEvent{Code: KeyRight, Shift: true}, action{kind: actWidthSet, n: 80}, true},
+		{KeyEvent{Code: KeyEnter}, action{kind: actExit}, true},
+		// Ctrl+Shift falls back to the Ctrl binding
+		{KeyEvent{Code: KeyUp, Ctrl: true, Shift: true, ShiftCtrl: true}, action{kind: actHeightBy, n: 200}, true},
+		{KeyEvent{Code: KeyChar, Char: 'z'}, action{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := table.lookup(tt.ev)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("lookup(%v) = %+v, %v, want %+v, %v", tt.ev, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if got := table.keyFor(action{kind: actExit}); got != "Esc" {
+		t.Errorf("keyFor(exit) = %q, want the first key, Esc", got)
+	}
+	if got := table.keyFor(action{kind: actProfile, name: "nope"}); got != "" {
+		t.Errorf("keyFor(unbound) = %q, want \"\"", got)
+	}
+}
+
+func TestBindingTableErrors(t *testing.T) {
+	for _, overrides := range []map[string]string{
+		{"Hyper-x": "reset"},
+		{"x": "jump"},
+		{"x": "height+=0"},
+	} {
+		if _, err := newBindingTable(overrides); err == nil {
+			t.Errorf("newBindingTable(%v) succeeded, want an error", overrides)
+		}
+	}
+}
+
+func TestBindingHelp(t *testing.T) {
+	tests := []struct {
+		name         string
+		overrides    map[string]string
+		hasProfiles  bool
+		hasSnapshots bool
+		width        int
+		want         []string
+	}{
+		{
+			name:         "defaults",
+			hasSnapshots: true,
+			hasProfiles:  true,
+			width:        37,
+			want: []string{
+				"Up/Down: height ±1, S ±20, C ±200",
+				"Right/Left: width ±1, S ±20, C ±200",
+				"n: height  d: delta  w: width",
+				"p: next profile  s: snapshot  u: undo",
+				"C-r: redo  Space: toggle  r: reset",
+				"C-z: suspend  Esc/Enter: exit",
+			},
+		},
+		{
+			name:         "without profiles",
+			hasSnapshots: true,
+			width:        37,
+			want: []string{
+				"Up/Down: height ±1, S ±20, C ±200",
+				"Right/Left: width ±1, S ±20, C ±200",
+				"n: height  d: delta  w: width",
+				"s: snapshot  u: undo  C-r: redo",
+				"Space: toggle  r: reset  C-z: suspend",
+				"Esc/Enter: exit",
+			},
+		},
+		{
+			name:  "without snapshots",
+			width: 37,
+			want: []string{
+				"Up/Down: height ±1, S ±20, C ±200",
+				"Right/Left: width ±1, S ±20, C ±200",
+				"n: height  d: delta  w: width",
+				"u: undo  C-r: redo  Space: toggle",
+				"r: reset  C-z: suspend",
+				"Esc/Enter: exit",
+			},
+		},
+		{
+			name:         "custom steps, extra keys and unbound keys",
+			hasSnapshots: true,
+			overrides:    map[string]string{"j": "height-=5", "k": "height+=5", "s": "none", "C-x": "reset", "M-q": "exit"},
+			width:        37,
+			want: []string{
+				"Up/Down: height ±1, S ±20, C ±200",
+				"Right/Left: width ±1, S ±20, C ±200",
+				"n: height  d: delta  w: width",
+				"u: undo  C-r: redo  Space: toggle",
+				"r/C-x: reset  C-z: suspend",
+				"Esc/Enter/M-q: exit  k/j: height ±5",
+			},
+		},
+		{
+			name:         "unpaired step",
+			hasSnapshots: true,
+			overrides:    map[string]string{"Down": "none", "S-Down": "none", "C-Down": "none"},
+			width:        37,
+			want: []string{
+				"Up: height +1  S-Up: height +20",
+				"C-Up: height +200",
+				"Right/Left: width ±1, S ±20, C ±200",
+				"n: height  d: delta  w: width",
+				"s: snapshot  u: undo  C-r: redo",
+				"Space: toggle  r: reset  C-z: suspend",
+				"Esc/Enter: exit",
+			},
+		},
+		{
+			name:         "narrow",
+			hasSnapshots: true,
+			overrides:    map[string]string{"Up": "none", "Down": "none", "S-Up": "none", "S-Down": "none", "C-Up": "none", "C-Down": "none"},
+			width:        12,
+			want: []string{
+				"Right/Left:…",
+				"n: height",
+				"d: delta",
+				"w: width",
+				"s: snapshot",
+				"u: undo",
+				"C-r: redo",
+				"Space: togg…",
+				"r: reset",
+				"C-z: suspend",
+				"Esc/Enter: …",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := newBindingTable(tt.overrides)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := table.help(tt.hasProfiles, tt.hasSnapshots, tt.width); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("help() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
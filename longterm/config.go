@@ -0,0 +1,156 @@
+package longterm
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ConfigEnv overrides the config file location
+const ConfigEnv = "LONG_TERM_CONFIG"
+
+// Profile is a named set of size settings. Unset fields keep the values
+// the profile is applied on top of.
+type Profile struct {
+	Height     *int `toml:"height"`
+	Delta      *int `toml:"delta"`
+	Width      *int `toml:"width"`
+	WidthDelta *int `toml:"width_delta"`
+}
+
+// EscapeConfig selects the key that enters command mode. Zero fields
+// keep the defaults.
+type EscapeConfig struct {
+	Key    string        `toml:"key"`
+	Count  int           `toml:"count"`
+	Window time.Duration `toml:"window"` // e.g. "500ms"
+}
+
+// Config is the contents of config.toml
+type Config struct {
+	Profiles map[string]Profile `toml:"profile"`
+	Rules    []Rule             `toml:"rule"`
+	Escape   EscapeConfig       `toml:"escape"`
+	Bindings map[string]string  `toml:"bindings"` // Key description to action
+}
+
+// ConfigPath returns $LONG_TERM_CONFIG, or config.toml under
+// $XDG_CONFIG_HOME/long-term (default ~/.config/long-term)
+func ConfigPath() string {
+	if path := os.Getenv(ConfigEnv); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "long-term", "config.toml")
+}
+
+// LoadConfig reads the config at path. A missing file yields an empty
+// config rather than an error.
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+	if _, err := toml.DecodeFile(path, cfg); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to load config %s: %w", path, err)
+	}
+	if cfg.Escape.Key != "" {
+		if _, err := ParseEscapeKey(cfg.Escape.Key); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+	if _, err := newBindingTable(cfg.Bindings); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ProfileNames returns the configured profile names in sorted order
+func (c *Config) ProfileNames() []string {
+	return profileNames(c.Profiles)
+}
+
+func profileNames(profiles map[string]Profile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Apply returns opts with the profile's settings layered on top. As with
+// the flags, an absolute height takes precedence over a delta.
+func (p Profile) Apply(opts Options) Options {
+	if p.Delta != nil {
+		opts.Mode = SizeDelta
+		opts.Value = *p.Delta
+	}
+	if p.Height != nil {
+		opts.Mode = SizeAbsolute
+		opts.Value = *p.Height
+	}
+	if p.Width != nil {
+		opts.Width = *p.Width
+		opts.WidthDelta = 0
+	}
+	if p.WidthDelta != nil {
+		opts.WidthDelta = *p.WidthDelta
+	}
+	return opts
+}
+
+// SetProfile applies the named profile on top of the session's launch
+// options
+func (s *Session) SetProfile(name string) error {
+	p, ok := s.opts.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	s.clearRule()
+	s.applyOptions(p.Apply(s.opts))
+	s.profile.Store(name)
+	s.resize()
+	s.triggerRefresh()
+	return nil
+}
+
+// NextProfile switches to the profile after the current one, wrapping
+// around, and returns its name
+func (s *Session) NextProfile() (string, error) {
+	names := profileNames(s.opts.Profiles)
+	if len(names) == 0 {
+		return "", fmt.Errorf("no profiles configured")
+	}
+	next := names[0]
+	current := s.Profile()
+	for i, name := range names {
+		if name == current {
+			next = names[(i+1)%len(names)]
+			break
+		}
+	}
+	return next, s.SetProfile(next)
+}
+
+// Profile returns the name of the active profile, or "" if none
+func (s *Session) Profile() string {
+	name, _ := s.profile.Load().(string)
+	return name
+}
@@ -0,0 +1,275 @@
+package longterm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SocketEnv is the environment variable exported to the child with the
+// control socket path
+const SocketEnv = "LONG_TERM_SOCKET"
+
+// Status is the state reported by the control socket's status command
+type Status struct {
+	Rows       int    `json:"rows"`
+	Cols       int    `json:"cols"`
+	Mode       string `json:"mode"` // "absolute", "delta" or "real"
+	Height     int    `json:"height"`
+	Delta      int    `json:"delta"`
+	Width      int    `json:"width"` // 0 when the real width is passed through
+	WidthDelta int    `json:"width_delta"`
+	RealRows   int    `json:"real_rows"`
+	RealCols   int    `json:"real_cols"`
+	UseReal    bool   `json:"use_real"`
+	Profile    string `json:"profile,omitempty"`
+	Rule       string `json:"rule,omitempty"`
+
+	Foreground    string `json:"foreground,omitempty"`
+	ForegroundPID int    `json:"foreground_pid,omitempty"`
+
+	Modes Modes `json:"modes"` // Terminal modes set by the child
+
+	Resources *Resources `json:"resources,omitempty"` // Once the child has started
+}
+
+// DefaultSocketPath returns a per-process socket path under
+// $XDG_RUNTIME_DIR, falling back to the temp directory
+func DefaultSocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("long-term-%d.sock", os.Getpid()))
+}
+
+// Status returns the current effective and real size
+func (s *Session) Status() Status {
+	w, h := s.termSize()
+	st := Status{
+		Rows:       s.targetHeight(h),
+		Cols:       s.targetWidth(w),
+		Height:     int(s.currentHeight.Load()),
+		Delta:      int(s.currentDelta.Load()),
+		Width:      int(s.currentWidth.Load()),
+		WidthDelta: int(s.currentWidthDelta.Load()),
+		RealRows:   h,
+		RealCols:   w,
+		UseReal:    s.useRealSize.Load(),
+		Profile:    s.Profile(),
+		Modes:      s.Modes(),
+	}
+	fg := s.Foreground()
+	st.Foreground, st.ForegroundPID = fg.Name, fg.PGID
+	if s.cmd != nil && s.cmd.Process != nil {
+		// The child leads the PTY's session
+		r := s.resources.sample(s.cmd.Process.Pid, s.ptyBytes.Load())
+		st.Resources = &r
+	}
+	p := s.rule.Load()
+	if p != nil {
+		st.Rule = p.name
+	}
+	if st.UseReal || (p != nil && p.opts.Real) {
+		st.Mode = "real"
+	} else if p != nil {
+		st.Mode = "rule"
+	} else if st.Delta != 0 {
+		st.Mode = "delta"
+	} else {
+		st.Mode = "absolute"
+	}
+	return st
+}
+
+// SocketPath returns the control socket path, or "" if none is listening
+func (s *Session) SocketPath() string {
+	if s.control == nil {
+		return ""
+	}
+	return s.opts.ControlSocket
+}
+
+// listenControl opens the control socket, replacing a stale one. Nothing is
+// accepted until serveControl runs once the child has started.
+func (s *Session) listenControl() error {
+	path := s.opts.ControlSocket
+	if _, err := os.Stat(path); err == nil {
+		// Only remove it if nobody is listening
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("control socket %s is in use", path)
+		}
+		os.Remove(path)
+	}
+	// Bind in a private directory and only move the socket into place
+	// once it is owner-only, so nobody else can connect in between
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".long-term-")
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	// The listener would unlink tmp on Close; shutdown removes path instead
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err = os.Chmod(tmp, 0600); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		ln.Close()
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	s.control = ln
+	return nil
+}
+
+// closeControl stops listening on the control socket and removes it
+func (s *Session) closeControl() {
+	if s.control != nil {
+		s.control.Close()
+		os.Remove(s.opts.ControlSocket)
+	}
+}
+
+func (s *Session) serveControl(ln net.Listener) {
+	defer s.guard()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleControlConn(conn)
+	}
+}
+
+// handleControlConn reads one command per line and writes one reply per line
+func (s *Session) handleControlConn(conn net.Conn) {
+	defer s.guard()
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fmt.Fprintf(conn, "%s\n", s.execControl(line))
+	}
+}
+
+// execControl runs a single control command and returns the reply
+func (s *Session) execControl(line string) string {
+	fields := strings.Fields(line)
+	cmd, args := fields[0], fields[1:]
+
+	var err error
+	switch cmd {
+	case "status":
+		data, _ := json.Marshal(s.Status())
+		return string(data)
+	case "set-height":
+		if len(args) != 1 {
+			return "error: usage: set-height N"
+		}
+		var val int
+		if val, err = ParseHeight(args[0]); err == nil {
+			err = s.SetHeight(val)
+		}
+	case "set-delta":
+		if len(args) != 1 {
+			return "error: usage: set-delta ±N"
+		}
+		var val int
+		if val, err = ParseDelta(args[0]); err == nil {
+			err = s.SetDelta(val)
+		}
+	case "set-width":
+		if len(args) != 1 {
+			return "error: usage: set-width N"
+		}
+		var val int
+		if val, err = ParseWidth(args[0]); err == nil {
+			err = s.SetWidth(val)
+		}
+	case "set-width-delta":
+		if len(args) != 1 {
+			return "error: usage: set-width-delta ±N"
+		}
+		var val int
+		if val, err = ParseDelta(args[0]); err == nil {
+			err = s.SetWidthDelta(val)
+		}
+	case "profile":
+		if len(args) != 1 {
+			return "error: usage: profile NAME"
+		}
+		err = s.SetProfile(args[0])
+	case "toggle-real", "toggle":
+		s.ToggleReal()
+	case "reset":
+		s.Reset()
+	default:
+		return fmt.Sprintf("error: unknown command %q", cmd)
+	}
+
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return "ok"
+}
+
+// Client sends commands to a session's control socket
+type Client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Dial connects to the control socket at path
+func Dial(path string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// Do sends a command and returns its reply. Replies of the form
+// "error: ..." are returned as errors.
+func (c *Client) Do(command string) (string, error) {
+	if _, err := fmt.Fprintf(c.conn, "%s\n", command); err != nil {
+		return "", err
+	}
+	reply, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	reply = strings.TrimRight(reply, "\n")
+	if msg, ok := strings.CutPrefix(reply, "error: "); ok {
+		return "", fmt.Errorf("%s", msg)
+	}
+	return reply, nil
+}
+
+// Status fetches and decodes the session status
+func (c *Client) Status() (Status, error) {
+	var st Status
+	reply, err := c.Do("status")
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal([]byte(reply), &st)
+	return st, err
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,71 @@
+package longterm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExecControl(t *testing.T) {
+	tests := []struct {
+		line  string
+		reply string // Or its prefix, for errors
+		want  Status // Fields checked after the command
+	}{
+		{"set-height 500", "ok", Status{Rows: 500, Height: 500, Mode: "absolute"}},
+		{"set-height x", "error: ", Status{Rows: 100, Height: 100, Mode: "absolute"}},
+		{"set-height 0", "error: ", Status{Rows: 100, Height: 100, Mode: "absolute"}},
+		{"set-height", "error: usage: set-height N", Status{Rows: 100, Height: 100, Mode: "absolute"}},
+		{"set-height 1 2", "error: usage: set-height N", Status{Rows: 100, Height: 100, Mode: "absolute"}},
+		{"set-delta +20", "ok", Status{Rows: 44, Height: 100, Delta: 20, Mode: "delta"}},
+		{"set-delta -4", "ok", Status{Rows: 20, Height: 100, Delta: -4, Mode: "delta"}},
+		{"set-delta 20", "error: ", Status{Rows: 100, Height: 100, Mode: "absolute"}},
+		{"set-delta", "error: usage: set-delta ±N", Status{Rows: 100, Height: 100, Mode: "absolute"}},
+		{"set-width 120", "ok", Status{Rows: 100, Cols: 120, Height: 100, Width: 120, Mode: "absolute"}},
+		{"set-width -1", "error: ", Status{Rows: 100, Height: 100, Mode: "absolute"}},
+		{"set-width-delta -10", "ok", Status{Rows: 100, Cols: 70, Height: 100, WidthDelta: -10, Mode: "absolute"}},
+		{"set-width-delta 10", "error: ", Status{Rows: 100, Height: 100, Mode: "absolute"}},
+		{"profile tall", "ok", Status{Rows: 5000, Height: 5000, Profile: "tall", Mode: "absolute"}},
+		{"profile nope", `error: unknown profile "nope"`, Status{Rows: 100, Height: 100, Mode: "absolute"}},
+		{"profile", "error: usage: profile NAME", Status{Rows: 100, Height: 100, Mode: "absolute"}},
+		{"toggle", "ok", Status{Rows: 24, Height: 100, UseReal: true, Mode: "real"}},
+		{"toggle-real", "ok", Status{Rows: 24, Height: 100, UseReal: true, Mode: "real"}},
+		{"reset", "ok", Status{Rows: 100, Height: 100, Mode: "absolute"}},
+		{"jump 5", `error: unknown command "jump"`, Status{Rows: 100, Height: 100, Mode: "absolute"}},
+	}
+	tall := 5000
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			s := NewSession([]string{"true"}, Options{
+				Stdin:    strings.NewReader(""),
+				Mode:     SizeAbsolute,
+				Value:    100,
+				Profiles: map[string]Profile{"tall": {Height: &tall}},
+			})
+			reply := s.execControl(tt.line)
+			if reply != tt.reply && !(tt.reply == "error: " && strings.HasPrefix(reply, tt.reply)) {
+				t.Errorf("reply = %q, want %q", reply, tt.reply)
+			}
+			st := s.Status()
+			if tt.want.Cols == 0 {
+				tt.want.Cols = 80
+			}
+			got := Status{Rows: st.Rows, Cols: st.Cols, Height: st.Height, Delta: st.Delta, Width: st.Width,
+				WidthDelta: st.WidthDelta, UseReal: st.UseReal, Profile: st.Profile, Mode: st.Mode}
+			if got != tt.want {
+				t.Errorf("status = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecControlStatus(t *testing.T) {
+	s := NewSession([]string{"true"}, Options{Stdin: strings.NewReader(""), Mode: SizeDelta, Value: 10})
+	var st Status
+	if err := json.Unmarshal([]byte(s.execControl("status")), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Rows != 34 || st.Cols != 80 || st.RealRows != 24 || st.Delta != 10 || st.Mode != "delta" {
+		t.Errorf("status = %+v, want 80x34 from 80x24 with delta 10", st)
+	}
+}
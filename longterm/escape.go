@@ -0,0 +1,79 @@
+package longterm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for the key that enters command mode
+const (
+	DefaultEscapeKey    = `C-\`
+	DefaultEscapeCount  = 3
+	DefaultEscapeWindow = 500 * time.Millisecond
+)
+
+// functionKeys are the xterm encodings of F1-F12
+var functionKeys = map[string]string{
+	"F1": "\033OP", "F2": "\033OQ", "F3": "\033OR", "F4": "\033OS",
+	"F5": "\033[15~", "F6": "\033[17~", "F7": "\033[18~", "F8": "\033[19~",
+	"F9": "\033[20~", "F10": "\033[21~", "F11": "\033[23~", "F12": "\033[24~",
+}
+
+// ParseEscapeKey converts a key description to the bytes the terminal
+// sends for it. Accepted forms are C-x or ^x for control keys, M-x for
+// Alt, F1-F12, 0xNN for a raw byte, or a single character.
+func ParseEscapeKey(spec string) ([]byte, error) {
+	switch {
+	case spec == "":
+		return nil, fmt.Errorf("empty escape key")
+	case functionKeys[strings.ToUpper(spec)] != "":
+		return []byte(functionKeys[strings.ToUpper(spec)]), nil
+	case strings.HasPrefix(spec, "0x") || strings.HasPrefix(spec, "0X"):
+		n, err := strconv.ParseUint(spec[2:], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid escape key %q: bad byte value", spec)
+		}
+		return []byte{byte(n)}, nil
+	case strings.HasPrefix(spec, "M-") && len(spec) > 2:
+		rest, err := ParseEscapeKey(spec[2:])
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{0x1B}, rest...), nil
+	case (strings.HasPrefix(spec, "C-") && len(spec) > 2) || (strings.HasPrefix(spec, "^") && len(spec) > 1):
+		key := strings.TrimPrefix(strings.TrimPrefix(spec, "C-"), "^")
+		if strings.EqualFold(key, "space") {
+			return []byte{0x00}, nil
+		}
+		if len(key) != 1 {
+			return nil, fmt.Errorf("invalid escape key %q: control keys take a single character", spec)
+		}
+		c := key[0]
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c == '?' {
+			return []byte{0x7F}, nil
+		}
+		if c < '@' || c > '_' {
+			return nil, fmt.Errorf("invalid escape key %q: no control code for %q", spec, key)
+		}
+		return []byte{c - '@'}, nil
+	case len([]rune(spec)) == 1:
+		return []byte(spec), nil
+	}
+	return nil, fmt.Errorf("invalid escape key %q (want C-x, M-x, F1-F12, 0xNN or a single character)", spec)
+}
+
+// escapeKeyName formats a key description for display, e.g. C-\ as Ctrl+\
+func escapeKeyName(spec string) string {
+	switch {
+	case strings.HasPrefix(spec, "C-"):
+		return "Ctrl+" + spec[2:]
+	case strings.HasPrefix(spec, "M-"):
+		return "Alt+" + spec[2:]
+	}
+	return spec
+}
@@ -0,0 +1,67 @@
+package longterm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseEscapeKey(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{spec: `C-\`, want: "\x1c"},
+		{spec: "C-a", want: "\x01"},
+		{spec: "C-A", want: "\x01"},
+		{spec: "^]", want: "\x1d"},
+		{spec: "C-space", want: "\x00"},
+		{spec: "C-@", want: "\x00"},
+		{spec: "C-?", want: "\x7f"},
+		{spec: "M-x", want: "\x1bx"},
+		{spec: "M-C-a", want: "\x1b\x01"},
+		{spec: "F1", want: "\x1bOP"},
+		{spec: "f5", want: "\x1b[15~"},
+		{spec: "F12", want: "\x1b[24~"},
+		{spec: "0x1c", want: "\x1c"},
+		{spec: "0XfF", want: "\xff"},
+		{spec: "q", want: "q"},
+		{spec: "é", want: "é"},
+		{spec: "", wantErr: true},
+		{spec: "C-ab", wantErr: true},
+		{spec: "C-1", wantErr: true},
+		{spec: "0x100", wantErr: true},
+		{spec: "0xzz", wantErr: true},
+		{spec: "M-", wantErr: true},
+		{spec: "M-bad", wantErr: true},
+		{spec: "F13", wantErr: true},
+		{spec: "xy", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseEscapeKey(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseEscapeKey(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !bytes.Equal(got, []byte(tt.want)) {
+			t.Errorf("ParseEscapeKey(%q) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeKeyName(t *testing.T) {
+	tests := []struct {
+		spec, want string
+	}{
+		{`C-\`, `Ctrl+\`},
+		{"M-x", "Alt+x"},
+		{"F1", "F1"},
+		{"^]", "^]"},
+		{"0x1c", "0x1c"},
+	}
+	for _, tt := range tests {
+		if got := escapeKeyName(tt.spec); got != tt.want {
+			t.Errorf("escapeKeyName(%q) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+}
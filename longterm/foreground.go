@@ -0,0 +1,115 @@
+package longterm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// foregroundPoll is how often the PTY's foreground process group is checked
+const foregroundPoll = 250 * time.Millisecond
+
+// Foreground describes the foreground process group of the PTY
+type Foreground struct {
+	PGID int
+	Name string
+	Argv []string
+}
+
+// Foreground returns the last observed foreground process group
+func (s *Session) Foreground() Foreground {
+	fg, _ := s.foreground.Load().(Foreground)
+	return fg
+}
+
+// foregroundLoop polls tcgetpgrp on the PTY master and re-evaluates the
+// size rules whenever a different job takes the foreground
+func (s *Session) foregroundLoop() {
+	defer s.guard()
+	ticker := time.NewTicker(foregroundPoll)
+	defer ticker.Stop()
+
+	lastPGID := -1
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		pgid, err := s.foregroundPGID()
+		if err != nil || pgid == lastPGID {
+			continue
+		}
+		lastPGID = pgid
+
+		fg := Foreground{PGID: pgid}
+		fg.Argv, fg.Name = processArgv(pgid)
+		s.foreground.Store(fg)
+
+		if pgid == s.cmd.Process.Pid {
+			// Back to the wrapped command, whose policy came from the launch options
+			if s.rule.Swap(nil) != nil {
+				s.resize()
+			}
+			s.triggerRefresh()
+			continue
+		}
+		if len(fg.Argv) > 0 {
+			s.applyRules(fg.Name, fg.Argv)
+		}
+		s.triggerRefresh()
+	}
+}
+
+// foregroundPGID returns the PTY's foreground process group without
+// switching the master to blocking mode
+func (s *Session) foregroundPGID() (int, error) {
+	conn, err := s.ptmx.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var pgid int
+	var ioctlErr error
+	err = conn.Control(func(fd uintptr) {
+		pgid, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pgid, ioctlErr
+}
+
+// processArgv returns the argv and short name of pid from /proc, falling
+// back to ps where /proc is unavailable
+func processArgv(pid int) ([]string, string) {
+	var name string
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); err == nil {
+		name = strings.TrimSpace(string(data))
+	}
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid)); err == nil && len(data) > 0 {
+		argv := strings.Split(string(bytes.TrimRight(data, "\x00")), "\x00")
+		if name == "" {
+			name = argv[0]
+		}
+		return argv, name
+	}
+	if name == "" {
+		out, err := exec.Command("ps", "-o", "command=", "-p", fmt.Sprint(pid)).Output()
+		if err != nil {
+			return nil, ""
+		}
+		argv := strings.Fields(string(out))
+		if len(argv) == 0 {
+			return nil, ""
+		}
+		return argv, filepath.Base(argv[0])
+	}
+	return []string{name}, name
+}
@@ -0,0 +1,125 @@
+package longterm
+
+import "strconv"
+
+// historyLimit bounds the number of size changes kept for undo
+const historyLimit = 50
+
+// sizeState is the size configuration at one point in the history
+type sizeState struct {
+	height, delta     int
+	width, widthDelta int
+	real              bool
+	rule              *sizePolicy
+	profile           string
+}
+
+// label is the short form of the state shown in the overlay, e.g. "500",
+// "Δ+20", "80x24" or "real"
+func (st sizeState) label() string {
+	switch {
+	case st.rule != nil:
+		return st.rule.name
+	case st.real:
+		return "real"
+	}
+	h := strconv.Itoa(st.height)
+	if st.delta != 0 {
+		h = "Δ" + signed(st.delta)
+	}
+	switch {
+	case st.widthDelta != 0:
+		return "Δ" + signed(st.widthDelta) + "x" + h
+	case st.width != 0:
+		return strconv.Itoa(st.width) + "x" + h
+	}
+	return h
+}
+
+// sizeHistory is a bounded undo/redo list of size states
+type sizeHistory struct {
+	entries []sizeState
+	pos     int // Index of the current state
+}
+
+// record makes st the current state, dropping anything that could have
+// been redone. Recording the current state again does nothing.
+func (h *sizeHistory) record(st sizeState) {
+	if len(h.entries) > 0 {
+		if h.entries[h.pos] == st {
+			return
+		}
+		h.entries = h.entries[:h.pos+1]
+	}
+	h.entries = append(h.entries, st)
+	if len(h.entries) > historyLimit {
+		h.entries = h.entries[len(h.entries)-historyLimit:]
+	}
+	h.pos = len(h.entries) - 1
+}
+
+// undo steps back to the previous state
+func (h *sizeHistory) undo() (sizeState, bool) {
+	if h.pos == 0 {
+		return sizeState{}, false
+	}
+	h.pos--
+	return h.entries[h.pos], true
+}
+
+// redo steps forward to the state undo left
+func (h *sizeHistory) redo() (sizeState, bool) {
+	if h.pos+1 >= len(h.entries) {
+		return sizeState{}, false
+	}
+	h.pos++
+	return h.entries[h.pos], true
+}
+
+// recent returns up to n distinct states other than the current one,
+// most recently recorded first
+func (h *sizeHistory) recent(n int) []sizeState {
+	var out []sizeState
+	seen := make(map[sizeState]bool)
+	if len(h.entries) > 0 {
+		seen[h.entries[h.pos]] = true
+	}
+	for i := len(h.entries) - 1; i >= 0 && len(out) < n; i-- {
+		if st := h.entries[i]; !seen[st] {
+			seen[st] = true
+			out = append(out, st)
+		}
+	}
+	return out
+}
+
+// sizeState captures the current size settings
+func (s *Session) sizeState() sizeState {
+	return sizeState{
+		height:     int(s.currentHeight.Load()),
+		delta:      int(s.currentDelta.Load()),
+		width:      int(s.currentWidth.Load()),
+		widthDelta: int(s.currentWidthDelta.Load()),
+		real:       s.useRealSize.Load(),
+		rule:       s.rule.Load(),
+		profile:    s.Profile(),
+	}
+}
+
+// applySize returns to size settings from the history
+func (s *Session) applySize(st sizeState) {
+	mode := SizeAbsolute
+	if st.delta != 0 {
+		mode = SizeDelta
+	}
+	s.modeState.Store(int32(mode))
+	s.currentHeight.Store(int32(st.height))
+	s.currentDelta.Store(int32(st.delta))
+	s.currentWidth.Store(int32(st.width))
+	s.currentWidthDelta.Store(int32(st.widthDelta))
+	s.useRealSize.Store(st.real)
+	s.rule.Store(st.rule)
+	s.profile.Store(st.profile)
+	s.resize()
+	s.triggerRefresh()
+}
@@ -0,0 +1,106 @@
+package longterm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSizeHistory(t *testing.T) {
+	a, b, c := sizeState{height: 100}, sizeState{height: 200}, sizeState{delta: 20}
+	var h sizeHistory
+	h.record(a)
+	h.record(a) // Recording the current state again does nothing
+	h.record(b)
+	h.record(c)
+	if len(h.entries) != 3 {
+		t.Fatalf("entries = %v, want 3", h.entries)
+	}
+
+	steps := []struct {
+		op   string
+		want sizeState
+		ok   bool
+	}{
+		{"undo", b, true},
+		{"undo", a, true},
+		{"undo", sizeState{}, false},
+		{"redo", b, true},
+		{"redo", c, true},
+		{"redo", sizeState{}, false},
+		{"undo", b, true},
+	}
+	for i, step := range steps {
+		var got sizeState
+		var ok bool
+		if step.op == "undo" {
+			got, ok = h.undo()
+		} else {
+			got, ok = h.redo()
+		}
+		if got != step.want || ok != step.ok {
+			t.Fatalf("step %d %s = %+v, %v, want %+v, %v", i, step.op, got, ok, step.want, step.ok)
+		}
+	}
+
+	// Recording after an undo drops what could have been redone
+	d := sizeState{real: true}
+	h.record(d)
+	if _, ok := h.redo(); ok {
+		t.Error("redo after recording succeeded")
+	}
+	if want := []sizeState{a, b, d}; !reflect.DeepEqual(h.entries, want) {
+		t.Errorf("entries = %+v, want %+v", h.entries, want)
+	}
+}
+
+func TestSizeHistoryLimit(t *testing.T) {
+	var h sizeHistory
+	for i := 1; i <= historyLimit+10; i++ {
+		h.record(sizeState{height: i})
+	}
+	if len(h.entries) != historyLimit {
+		t.Fatalf("len(entries) = %d, want %d", len(h.entries), historyLimit)
+	}
+	if h.entries[0].height != 11 || h.pos != historyLimit-1 {
+		t.Errorf("oldest = %d, pos = %d, want 11 and %d", h.entries[0].height, h.pos, historyLimit-1)
+	}
+}
+
+func TestSizeHistoryRecent(t *testing.T) {
+	var h sizeHistory
+	if got := h.recent(3); got != nil {
+		t.Errorf("recent on empty history = %v", got)
+	}
+	for _, n := range []int{1, 2, 1, 3, 2, 4} {
+		h.record(sizeState{height: n})
+	}
+	h.undo() // Current is now 2
+
+	var got []int
+	for _, st := range h.recent(3) {
+		got = append(got, st.height)
+	}
+	if want := []int{4, 3, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recent(3) = %v, want %v", got, want)
+	}
+}
+
+func TestSizeStateLabel(t *testing.T) {
+	tests := []struct {
+		st   sizeState
+		want string
+	}{
+		{sizeState{height: 500}, "500"},
+		{sizeState{delta: 20}, "Δ+20"},
+		{sizeState{delta: -5}, "Δ-5"},
+		{sizeState{height: 24, width: 80}, "80x24"},
+		{sizeState{delta: 100, widthDelta: -10}, "Δ-10xΔ+100"},
+		{sizeState{height: 500, real: true}, "real"},
+		{sizeState{rule: &sizePolicy{name: "less"}, real: true}, "less"},
+	}
+	for _, tt := range tests {
+		if got := tt.st.label(); got != tt.want {
+			t.Errorf("%+v.label() = %q, want %q", tt.st, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package longterm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// hostOutput forwards PTY output to the real terminal while mirroring it
+// into a model of what the terminal shows. Overlay painting goes through
+// it too, so the box is only ever drawn between complete escape sequences
+// and characters of the child's output.
+type hostOutput struct {
+	mu      sync.Mutex
+	w       io.Writer
+	screen  *Screen
+	ui      *uiRenderer
+	pending func() // Paint waiting for the stream to reach a boundary
+}
+
+func newHostOutput(w io.Writer, cols, rows int, ui *uiRenderer) *hostOutput {
+	h := &hostOutput{
+		w:      w,
+		screen: NewScreen(cols, rows),
+		ui:     ui,
+	}
+	ui.host = h.screen
+	return h
+}
+
+func (h *hostOutput) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n, err := h.w.Write(p)
+	shifts := h.screen.Shifts()
+	h.screen.Write(p[:n])
+	if h.ui.visible() && h.screen.Shifts() != shifts {
+		// Scrolling or insertion carried part of the box along with it
+		h.ui.damaged = true
+	}
+
+	if !h.screen.Ground() {
+		return n, err
+	}
+	if h.pending != nil {
+		paint := h.pending
+		h.pending = nil
+		paint()
+	} else {
+		h.ui.redraw()
+	}
+	return n, err
+}
+
+// paint runs f with the output stream at a sequence boundary: right away
+// if it is at one, otherwise after the write that completes the sequence.
+// A later paint replaces one that is still waiting.
+func (h *hostOutput) paint(f func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.screen.Ground() {
+		h.pending = nil
+		f()
+		return
+	}
+	h.pending = f
+}
+
+// resize follows a change of the real terminal size. The terminal's own
+// reflow can't be predicted, so a visible box repaints the whole screen.
+func (h *hostOutput) resize(cols, rows int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.screen.Resize(cols, rows)
+	if h.ui.visible() {
+		h.ui.damaged = true
+	}
+}
+
+// control returns the overlay control under a mouse report
+func (h *hostOutput) control(x, y int) (uiControl, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ui.controlAt(x, y)
+}
+
+// moveTo places the model's cursor, for output that predates the session
+func (h *hostOutput) moveTo(row, col int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(h.screen, "\033[%d;%dH", row, col)
+}
+
+// queryCursor asks the terminal for the cursor position (1-based) with a
+// DSR. fd must be in raw mode. Bytes read around the report, typically
+// early keystrokes, are returned so they can be passed on.
+func queryCursor(fd int, tty io.Writer) (row, col int, rest []byte, ok bool) {
+	if _, err := io.WriteString(tty, "\033[6n"); err != nil {
+		return 0, 0, nil, false
+	}
+
+	var buf []byte
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return 0, 0, buf, false
+		}
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, int(remaining/time.Millisecond)+1)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || n == 0 {
+			return 0, 0, buf, false
+		}
+		chunk := make([]byte, 64)
+		n, err = unix.Read(fd, chunk)
+		if err != nil || n == 0 {
+			return 0, 0, buf, false
+		}
+		buf = append(buf, chunk[:n]...)
+
+		// Look for ESC [ row ; col R
+		for start := bytes.Index(buf, []byte("\033[")); start >= 0; {
+			end := bytes.IndexByte(buf[start:], 'R')
+			if end < 0 {
+				break
+			}
+			if _, err := fmt.Sscanf(string(buf[start:start+end+1]), "\033[%d;%dR", &row, &col); err == nil {
+				rest = append(buf[:start:start], buf[start+end+1:]...)
+				return row, col, rest, true
+			}
+			next := bytes.Index(buf[start+1:], []byte("\033["))
+			if next < 0 {
+				break
+			}
+			start += 1 + next
+		}
+	}
+}
+
+// teardown clears the overlay and shows the cursor for good. It gives up
+// waiting for the lock after a while, since the goroutine holding it may
+// be one that panicked, and then writes only what needs no model.
+func (h *hostOutput) teardown() {
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for !h.mu.TryLock() {
+		if time.Now().After(deadline) {
+			h.ui.finish()
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	defer h.mu.Unlock()
+	h.pending = nil
+	h.ui.clearBox()
+	h.ui.finish()
+}
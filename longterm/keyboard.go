@@ -0,0 +1,525 @@
+package longterm
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// KeyCode represents parsed keyboard input
+type KeyCode int
+
+const (
+	KeyUnknown KeyCode = iota
+	KeyChar            // Regular character (a-z, 0-9, space, etc)
+	KeyESC
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyBackspace
+	KeyEnter
+	KeyTab
+	KeyHome
+	KeyEnd
+	KeyPageUp
+	KeyPageDown
+	KeyInsert
+	KeyDelete
+	KeyF1 // KeyF1 + n-1 is Fn, up to F12
+	KeyF2
+	KeyF3
+	KeyF4
+	KeyF5
+	KeyF6
+	KeyF7
+	KeyF8
+	KeyF9
+	KeyF10
+	KeyF11
+	KeyF12
+	KeyPaste // Bracketed paste; the pasted text is in Text
+	KeyMouse // Mouse report; the details are in Mouse
+)
+
+// Mouse buttons
+const (
+	MouseLeft = iota
+	MouseMiddle
+	MouseRight
+	MouseNone // Motion without a button held
+	MouseWheelUp
+	MouseWheelDown
+)
+
+// MouseEvent describes a mouse report
+type MouseEvent struct {
+	Button  int
+	X, Y    int  // Cell, 0-based
+	Release bool // Button released
+	Motion  bool // Pointer moved, e.g. dragging with Button held
+}
+
+// KeyEvent represents a parsed keyboard event
+type KeyEvent struct {
+	Code  KeyCode
+	Char  rune       // Valid when Code == KeyChar
+	Text  string     // Valid when Code == KeyPaste
+	Mouse MouseEvent // Valid when Code == KeyMouse
+	Shift bool       // Modifier flags
+	Ctrl  bool
+	Alt   bool
+	Meta  bool
+
+	// ShiftCtrl is set along with Shift and Ctrl when both are held
+	ShiftCtrl bool
+}
+
+// keyNames are the names used by KeyEvent.String for non-character keys
+var keyNames = map[KeyCode]string{
+	KeyESC: "Esc", KeyUp: "Up", KeyDown: "Down", KeyLeft: "Left", KeyRight: "Right",
+	KeyBackspace: "Backspace", KeyEnter: "Enter", KeyTab: "Tab",
+	KeyHome: "Home", KeyEnd: "End", KeyPageUp: "PageUp", KeyPageDown: "PageDown",
+	KeyInsert: "Insert", KeyDelete: "Delete", KeyPaste: "Paste", KeyMouse: "Mouse",
+}
+
+// String describes the key in the same form key bindings use, with
+// modifier prefixes such as C- and S-, e.g. "C-S-Up", "M-x" or "F5"
+func (e KeyEvent) String() string {
+	var b strings.Builder
+	if e.Ctrl {
+		b.WriteString("C-")
+	}
+	if e.Alt {
+		b.WriteString("M-")
+	}
+	if e.Meta {
+		b.WriteString("Meta-")
+	}
+	if e.Shift {
+		b.WriteString("S-")
+	}
+	switch {
+	case e.Code == KeyChar && e.Char == ' ':
+		b.WriteString("Space")
+	case e.Code == KeyChar:
+		b.WriteRune(e.Char)
+	case e.Code >= KeyF1 && e.Code <= KeyF12:
+		b.WriteString("F" + strconv.Itoa(int(e.Code-KeyF1)+1))
+	case keyNames[e.Code] != "":
+		b.WriteString(keyNames[e.Code])
+	default:
+		b.WriteString("Unknown")
+	}
+	return b.String()
+}
+
+// setModifiers applies an xterm modifier parameter (1 + bitmask of
+// Shift=1, Alt=2, Ctrl=4, Meta=8). Kitty's Super bit is treated as Meta.
+func (e *KeyEvent) setModifiers(param int) {
+	if param < 2 {
+		return
+	}
+	bits := param - 1
+	e.Shift = e.Shift || bits&1 != 0
+	e.Alt = e.Alt || bits&2 != 0
+	e.Ctrl = e.Ctrl || bits&4 != 0
+	e.Meta = e.Meta || bits&(8|32) != 0
+	e.ShiftCtrl = e.Shift && e.Ctrl
+}
+
+// Parser states
+const (
+	kbIdle  = iota
+	kbEsc   // Saw ESC
+	kbCSI   // Saw ESC [
+	kbSS3   // Saw ESC O
+	kbPaste // Inside a bracketed paste
+	kbMouse // Reading the coordinates of an X10 mouse report
+)
+
+// pasteEnd terminates a bracketed paste
+const pasteEnd = "\033[201~"
+
+// keyboardParser reads stdin and emits KeyEvent structs. It understands
+// xterm and VT220 encodings (CSI and SS3 cursor keys, ~ keys, modifier
+// parameters), Alt as an ESC prefix, bracketed paste, xterm's
+// modifyOtherKeys, the kitty keyboard protocol's CSI u form and mouse
+// reports. Which mouse encoding applies follows the terminal's modes.
+type keyboardParser struct {
+	mu         sync.Mutex
+	eventChan  chan KeyEvent
+	buf        []byte
+	state      int
+	alt        bool // ESC prefix seen before the current sequence
+	utf8buf    []byte
+	escTimer   *time.Timer
+	escGen     int
+	escTimeout time.Duration
+	modes      Modes // The terminal's modes, see setModes
+}
+
+func newKeyboardParser() *keyboardParser {
+	return &keyboardParser{
+		eventChan:  make(chan KeyEvent, 10),
+		buf:        make([]byte, 0, 16),
+		escTimeout: 100 * time.Millisecond,
+	}
+}
+
+// Write implements io.Writer to observe stdin bytes
+func (kp *keyboardParser) Write(p []byte) (n int, err error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	kp.stopEscTimer()
+	for _, b := range p {
+		kp.processByte(b)
+	}
+	if kp.state == kbEsc {
+		// A lone ESC is the Escape key unless more bytes follow quickly
+		kp.escGen++
+		gen := kp.escGen
+		kp.escTimer = time.AfterFunc(kp.escTimeout, func() { kp.escExpired(gen) })
+	}
+	return len(p), nil
+}
+
+// setModes tells the parser which modes the terminal is in. Mouse reports
+// other than SGR are only recognized while mouse tracking is on, and are
+// decoded according to the mouse encoding.
+func (kp *keyboardParser) setModes(m Modes) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	kp.modes = m
+}
+
+// reset drops any partial sequence, e.g. when command mode is left
+func (kp *keyboardParser) reset() {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	kp.stopEscTimer()
+	kp.state = kbIdle
+	kp.alt = false
+	kp.buf = kp.buf[:0]
+	kp.utf8buf = kp.utf8buf[:0]
+}
+
+func (kp *keyboardParser) escExpired(gen int) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if gen == kp.escGen && kp.state == kbEsc {
+		kp.state = kbIdle
+		kp.emit(KeyEvent{Code: KeyESC, Alt: kp.alt})
+		kp.alt = false
+	}
+}
+
+func (kp *keyboardParser) stopEscTimer() {
+	if kp.escTimer != nil {
+		kp.escTimer.Stop()
+		kp.escTimer = nil
+	}
+	kp.escGen++
+}
+
+// emit sends an event, applying a pending Alt prefix. Callers hold kp.mu.
+func (kp *keyboardParser) emit(ev KeyEvent) {
+	if kp.alt {
+		ev.Alt = true
+		kp.alt = false
+	}
+	kp.eventChan <- ev
+}
+
+func (kp *keyboardParser) processByte(b byte) {
+	switch kp.state {
+	case kbIdle:
+		kp.ground(b)
+
+	case kbEsc:
+		switch b {
+		case '[':
+			kp.state = kbCSI
+			kp.buf = kp.buf[:0]
+		case 'O':
+			kp.state = kbSS3
+			kp.buf = kp.buf[:0]
+		case 0x1B:
+			if kp.alt {
+				// ESC ESC ESC: the first pair is Alt+Escape
+				kp.emit(KeyEvent{Code: KeyESC})
+			}
+			kp.alt = true
+		default:
+			// ESC followed by a key is that key with Alt
+			kp.state = kbIdle
+			kp.alt = true
+			kp.ground(b)
+		}
+
+	case kbCSI, kbSS3:
+		kp.buf = append(kp.buf, b)
+		if b >= 0x40 && b <= 0x7E { // Final byte of sequence
+			state := kp.state
+			kp.state = kbIdle
+			kp.parseSequence(state == kbSS3)
+		} else if len(kp.buf) > 64 {
+			kp.state = kbIdle
+			kp.alt = false
+		}
+
+	case kbPaste:
+		kp.buf = append(kp.buf, b)
+		if bytes.HasSuffix(kp.buf, []byte(pasteEnd)) {
+			text := string(kp.buf[:len(kp.buf)-len(pasteEnd)])
+			kp.state = kbIdle
+			kp.buf = kp.buf[:0]
+			kp.emit(KeyEvent{Code: KeyPaste, Text: text})
+		}
+
+	case kbMouse:
+		kp.buf = append(kp.buf, b)
+		if c, ok := mouseCoords(kp.buf, kp.modes.MouseEncoding == 1005); ok {
+			kp.state = kbIdle
+			kp.buf = kp.buf[:0]
+			kp.emit(legacyMouseEvent(c[0], c[1]-32, c[2]-32))
+		} else if len(kp.buf) > 12 {
+			kp.state = kbIdle
+			kp.buf = kp.buf[:0]
+		}
+	}
+}
+
+// ground handles a byte outside any escape sequence
+func (kp *keyboardParser) ground(b byte) {
+	if len(kp.utf8buf) > 0 || b >= 0x80 {
+		kp.utf8buf = append(kp.utf8buf, b)
+		if !utf8.FullRune(kp.utf8buf) {
+			return
+		}
+		r, _ := utf8.DecodeRune(kp.utf8buf)
+		kp.utf8buf = kp.utf8buf[:0]
+		kp.emit(KeyEvent{Code: KeyChar, Char: r})
+		return
+	}
+
+	switch {
+	case b == 0x1B: // ESC
+		kp.state = kbEsc
+	case b == 0x7F || b == 0x08: // Backspace
+		kp.emit(KeyEvent{Code: KeyBackspace})
+	case b == '\r' || b == '\n':
+		kp.emit(KeyEvent{Code: KeyEnter})
+	case b == '\t':
+		kp.emit(KeyEvent{Code: KeyTab})
+	case b == 0x00:
+		kp.emit(KeyEvent{Code: KeyChar, Char: ' ', Ctrl: true})
+	case b < 0x20: // Ctrl+letter and friends
+		kp.emit(KeyEvent{Code: KeyChar, Char: controlChar(b), Ctrl: true})
+	default: // Printable ASCII
+		kp.emit(KeyEvent{Code: KeyChar, Char: rune(b)})
+	}
+}
+
+// controlChar returns the key that produces control byte b with Ctrl
+func controlChar(b byte) rune {
+	c := rune(b + '@')
+	if c >= 'A' && c <= 'Z' {
+		c += 'a' - 'A'
+	}
+	return c
+}
+
+// Final bytes of CSI and SS3 sequences that name a key directly
+var finalKeys = map[byte]KeyCode{
+	'A': KeyUp, 'B': KeyDown, 'C': KeyRight, 'D': KeyLeft,
+	'H': KeyHome, 'F': KeyEnd,
+	'P': KeyF1, 'Q': KeyF2, 'R': KeyF3, 'S': KeyF4,
+}
+
+// Numbers of VT220-style CSI n ~ keys
+var tildeKeys = map[int]KeyCode{
+	1: KeyHome, 2: KeyInsert, 3: KeyDelete, 4: KeyEnd, 5: KeyPageUp, 6: KeyPageDown,
+	7: KeyHome, 8: KeyEnd,
+	11: KeyF1, 12: KeyF2, 13: KeyF3, 14: KeyF4, 15: KeyF5,
+	17: KeyF6, 18: KeyF7, 19: KeyF8, 20: KeyF9, 21: KeyF10, 23: KeyF11, 24: KeyF12,
+}
+
+// parseSequence decodes the CSI or SS3 sequence in kp.buf (without the
+// ESC [ or ESC O prefix). Callers hold kp.mu.
+func (kp *keyboardParser) parseSequence(ss3 bool) {
+	final := kp.buf[len(kp.buf)-1]
+	body := string(kp.buf[:len(kp.buf)-1])
+	kp.buf = kp.buf[:0]
+
+	if strings.HasPrefix(body, "<") && (final == 'M' || final == 'm') {
+		// SGR mouse: CSI < button ; x ; y M, or m on release
+		kp.alt = false
+		var cb, x, y int
+		if _, err := fmt.Sscanf(body, "<%d;%d;%d", &cb, &x, &y); err == nil {
+			kp.emit(mouseEvent(cb, x-1, y-1, final == 'm'))
+		}
+		return
+	}
+	if final == 'M' && kp.modes.MouseTracking != 0 {
+		if body == "" {
+			// X10 mouse: CSI M followed by three coordinate bytes
+			kp.state = kbMouse
+			return
+		}
+		var cb, x, y int
+		if _, err := fmt.Sscanf(body, "%d;%d;%d", &cb, &x, &y); err == nil && kp.modes.MouseEncoding == 1015 {
+			// urxvt mouse: CSI button ; x ; y M, with X10's button offset
+			kp.alt = false
+			kp.emit(legacyMouseEvent(cb, x, y))
+			return
+		}
+	}
+
+	// Parameters are ';'-separated with ':'-separated sub-parameters
+	var params [][]int
+	if body != "" {
+		for _, field := range strings.Split(body, ";") {
+			var sub []int
+			for _, s := range strings.Split(field, ":") {
+				n, _ := strconv.Atoi(s)
+				sub = append(sub, n)
+			}
+			params = append(params, sub)
+		}
+	}
+	param := func(i int) int {
+		if i < len(params) && len(params[i]) > 0 {
+			return params[i][0]
+		}
+		return 0
+	}
+
+	var event KeyEvent
+	switch {
+	case ss3 || (final >= 'A' && final <= 'S' && finalKeys[final] != 0):
+		// ESC O A, ESC [ A, ESC [ 1 ; m A, and ESC O m A from some terminals
+		code, ok := finalKeys[final]
+		if !ok {
+			kp.alt = false
+			return
+		}
+		event.Code = code
+		if len(params) >= 2 {
+			event.setModifiers(param(1))
+		} else if ss3 && len(params) == 1 {
+			event.setModifiers(param(0))
+		}
+
+	case final == 'Z': // Shift+Tab
+		event = KeyEvent{Code: KeyTab, Shift: true}
+
+	case final == '~':
+		n := param(0)
+		switch {
+		case n == 200:
+			kp.state = kbPaste
+			return
+		case n == 27 && len(params) >= 3:
+			// xterm modifyOtherKeys: CSI 27 ; m ; code ~
+			event = keyFromCodepoint(param(2))
+			event.setModifiers(param(1))
+		default:
+			code, ok := tildeKeys[n]
+			if !ok {
+				kp.alt = false
+				return
+			}
+			event.Code = code
+			event.setModifiers(param(1))
+		}
+
+	case final == 'u':
+		// Kitty keyboard protocol: CSI code[:alts] ; mods[:event] ; text u
+		if len(params) >= 2 && len(params[1]) >= 2 && params[1][1] == 3 {
+			return // Key release
+		}
+		event = keyFromCodepoint(param(0))
+		event.setModifiers(param(1))
+
+	default:
+		// Focus reports, mouse and other sequences aren't keys
+		kp.alt = false
+		return
+	}
+
+	if event.Code == KeyUnknown {
+		kp.alt = false
+		return
+	}
+	kp.emit(event)
+}
+
+// mouseEvent decodes an xterm mouse button parameter: the button in the
+// low bits, then Shift=4, Alt=8, Ctrl=16, motion=32 and wheel=64
+func mouseEvent(cb, x, y int, release bool) KeyEvent {
+	m := MouseEvent{Button: cb & 3, X: x, Y: y, Release: release, Motion: cb&32 != 0}
+	if cb&64 != 0 {
+		m.Button = MouseWheelUp + cb&1
+	}
+	return KeyEvent{
+		Code:      KeyMouse,
+		Mouse:     m,
+		Shift:     cb&4 != 0,
+		Alt:       cb&8 != 0,
+		Ctrl:      cb&16 != 0,
+		ShiftCtrl: cb&4 != 0 && cb&16 != 0,
+	}
+}
+
+// legacyMouseEvent decodes an X10 or urxvt report, whose button is offset
+// by 32 and whose coordinates are 1-based. Button 3 is a release.
+func legacyMouseEvent(cb, x, y int) KeyEvent {
+	cb -= 32
+	release := cb&3 == 3 && cb&(32|64) == 0
+	return mouseEvent(cb, x-1, y-1, release)
+}
+
+// mouseCoords splits the button and coordinates of an X10 report, each a
+// byte offset by 32, or a UTF-8 character in the ?1005 encoding
+func mouseCoords(p []byte, utf bool) ([]int, bool) {
+	var c []int
+	for len(p) > 0 {
+		if !utf {
+			c, p = append(c, int(p[0])), p[1:]
+			continue
+		}
+		if !utf8.FullRune(p) {
+			return nil, false
+		}
+		r, n := utf8.DecodeRune(p)
+		c, p = append(c, int(r)), p[n:]
+	}
+	return c, len(c) == 3
+}
+
+// keyFromCodepoint maps a Unicode codepoint from modifyOtherKeys or the
+// kitty protocol to an event
+func keyFromCodepoint(cp int) KeyEvent {
+	switch cp {
+	case 0:
+		return KeyEvent{}
+	case 9:
+		return KeyEvent{Code: KeyTab}
+	case 13:
+		return KeyEvent{Code: KeyEnter}
+	case 27:
+		return KeyEvent{Code: KeyESC}
+	case 8, 127:
+		return KeyEvent{Code: KeyBackspace}
+	}
+	if cp < 0x20 || cp >= 57344 && cp <= 63743 {
+		// Control codes and kitty's private-use functional keys
+		return KeyEvent{}
+	}
+	return KeyEvent{Code: KeyChar, Char: rune(cp)}
+}
@@ -0,0 +1,164 @@
+package longterm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseKeys feeds input to a keyboard parser in the given modes and
+// returns the events it emits right away
+func parseKeys(input string, modes Modes) []KeyEvent {
+	kp := newKeyboardParser()
+	kp.eventChan = make(chan KeyEvent, 64)
+	kp.setModes(modes)
+	kp.Write([]byte(input))
+	var events []KeyEvent
+	for {
+		select {
+		case ev := <-kp.eventChan:
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func TestKeyboardParser(t *testing.T) {
+	tracking := Modes{MouseTracking: 1000}
+	tests := []struct {
+		name  string
+		input string
+		modes Modes
+		want  []KeyEvent
+	}{
+		{"chars", "ab", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'a'}, {Code: KeyChar, Char: 'b'}}},
+		{"utf8", "é✓", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'é'}, {Code: KeyChar, Char: '✓'}}},
+		{"enter", "\r\n", Modes{}, []KeyEvent{{Code: KeyEnter}, {Code: KeyEnter}}},
+		{"tab", "\t", Modes{}, []KeyEvent{{Code: KeyTab}}},
+		{"backspace", "\x7f\x08", Modes{}, []KeyEvent{{Code: KeyBackspace}, {Code: KeyBackspace}}},
+		{"ctrl letter", "\x01\x1a", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'a', Ctrl: true}, {Code: KeyChar, Char: 'z', Ctrl: true}}},
+		{"ctrl backslash", "\x1c", Modes{}, []KeyEvent{{Code: KeyChar, Char: '\\', Ctrl: true}}},
+		{"ctrl space", "\x00", Modes{}, []KeyEvent{{Code: KeyChar, Char: ' ', Ctrl: true}}},
+		{"alt char", "\033x", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'x', Alt: true}}},
+		{"alt ctrl", "\033\x01", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'a', Ctrl: true, Alt: true}}},
+		{"csi arrows", "\033[A\033[B\033[C\033[D", Modes{}, []KeyEvent{{Code: KeyUp}, {Code: KeyDown}, {Code: KeyRight}, {Code: KeyLeft}}},
+		{"ss3 arrows", "\033OA\033OD", Modes{}, []KeyEvent{{Code: KeyUp}, {Code: KeyLeft}}},
+		{"home end", "\033[H\033[F\033OH\033OF", Modes{}, []KeyEvent{{Code: KeyHome}, {Code: KeyEnd}, {Code: KeyHome}, {Code: KeyEnd}}},
+		{"ss3 function keys", "\033OP\033OS", Modes{}, []KeyEvent{{Code: KeyF1}, {Code: KeyF4}}},
+		{"tilde keys", "\033[2~\033[3~\033[5~\033[6~", Modes{}, []KeyEvent{{Code: KeyInsert}, {Code: KeyDelete}, {Code: KeyPageUp}, {Code: KeyPageDown}}},
+		{"vt220 home end", "\033[1~\033[4~\033[7~\033[8~", Modes{}, []KeyEvent{{Code: KeyHome}, {Code: KeyEnd}, {Code: KeyHome}, {Code: KeyEnd}}},
+		{"tilde function keys", "\033[11~\033[15~\033[24~", Modes{}, []KeyEvent{{Code: KeyF1}, {Code: KeyF5}, {Code: KeyF12}}},
+		{"unknown tilde", "\033[99~x", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'x'}}},
+		{"shift up", "\033[1;2A", Modes{}, []KeyEvent{{Code: KeyUp, Shift: true}}},
+		{"alt up", "\033[1;3A", Modes{}, []KeyEvent{{Code: KeyUp, Alt: true}}},
+		{"ctrl shift up", "\033[1;6A", Modes{}, []KeyEvent{{Code: KeyUp, Shift: true, Ctrl: true, ShiftCtrl: true}}},
+		{"meta up", "\033[1;9A", Modes{}, []KeyEvent{{Code: KeyUp, Meta: true}}},
+		{"ctrl page down", "\033[6;5~", Modes{}, []KeyEvent{{Code: KeyPageDown, Ctrl: true}}},
+		{"ss3 with modifier", "\033O5C", Modes{}, []KeyEvent{{Code: KeyRight, Ctrl: true}}},
+		{"esc prefixed arrow", "\033\033[A", Modes{}, []KeyEvent{{Code: KeyUp, Alt: true}}},
+		{"shift tab", "\033[Z", Modes{}, []KeyEvent{{Code: KeyTab, Shift: true}}},
+		{"modify other keys", "\033[27;5;105~", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'i', Ctrl: true}}},
+		{"modify other keys enter", "\033[27;2;13~", Modes{}, []KeyEvent{{Code: KeyEnter, Shift: true}}},
+		{"kitty char", "\033[97;5u", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'a', Ctrl: true}}},
+		{"kitty escape", "\033[27u", Modes{}, []KeyEvent{{Code: KeyESC}}},
+		{"kitty super", "\033[97;9u", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'a', Meta: true}}},
+		{"kitty alternates", "\033[97:65;2u", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'a', Shift: true}}},
+		{"kitty press and repeat", "\033[97;1:1u\033[97;1:2u", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'a'}, {Code: KeyChar, Char: 'a'}}},
+		{"kitty release ignored", "\033[97;1:3u", Modes{}, nil},
+		{"kitty private use ignored", "\033[57399u", Modes{}, nil},
+		{"focus report ignored", "\033[I\033[Ox", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'x'}}},
+		{"paste", "\033[200~a\033b\r\033[201~", Modes{}, []KeyEvent{{Code: KeyPaste, Text: "a\033b\r"}}},
+		{"sgr mouse press", "\033[<0;5;3M", Modes{}, []KeyEvent{{Code: KeyMouse, Mouse: MouseEvent{Button: MouseLeft, X: 4, Y: 2}}}},
+		{"sgr mouse release", "\033[<2;1;1m", Modes{}, []KeyEvent{{Code: KeyMouse, Mouse: MouseEvent{Button: MouseRight, Release: true}}}},
+		{"sgr mouse drag", "\033[<32;2;2M", Modes{}, []KeyEvent{{Code: KeyMouse, Mouse: MouseEvent{Button: MouseLeft, X: 1, Y: 1, Motion: true}}}},
+		{"sgr mouse motion", "\033[<35;2;2M", Modes{}, []KeyEvent{{Code: KeyMouse, Mouse: MouseEvent{Button: MouseNone, X: 1, Y: 1, Motion: true}}}},
+		{"sgr wheel", "\033[<64;1;1M\033[<65;1;1M", Modes{}, []KeyEvent{
+			{Code: KeyMouse, Mouse: MouseEvent{Button: MouseWheelUp}},
+			{Code: KeyMouse, Mouse: MouseEvent{Button: MouseWheelDown}},
+		}},
+		{"sgr mouse modifiers", "\033[<20;1;1M", Modes{}, []KeyEvent{{Code: KeyMouse, Shift: true, Ctrl: true, ShiftCtrl: true}}},
+		{"x10 mouse", "\033[M %#", tracking, []KeyEvent{{Code: KeyMouse, Mouse: MouseEvent{Button: MouseLeft, X: 4, Y: 2}}}},
+		{"x10 mouse release", "\033[M#!!", tracking, []KeyEvent{{Code: KeyMouse, Mouse: MouseEvent{Button: MouseNone, Release: true}}}},
+		{"x10 mouse split", "\033[M", tracking, nil},
+		{"x10 without tracking", "\033[Mx", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'x'}}},
+		{"utf8 mouse", "\033[M \xc8\x80!", Modes{MouseTracking: 1000, MouseEncoding: 1005}, []KeyEvent{
+			{Code: KeyMouse, Mouse: MouseEvent{Button: MouseLeft, X: 0x200 - 33, Y: 0}},
+		}},
+		{"urxvt mouse", "\033[32;5;3M", Modes{MouseTracking: 1000, MouseEncoding: 1015}, []KeyEvent{
+			{Code: KeyMouse, Mouse: MouseEvent{Button: MouseLeft, X: 4, Y: 2}},
+		}},
+		{"overlong sequence dropped", "\033[" + strings.Repeat("1", 65) + "x", Modes{}, []KeyEvent{{Code: KeyChar, Char: 'x'}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseKeys(tt.input, tt.modes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyboardParserSplitSequence(t *testing.T) {
+	kp := newKeyboardParser()
+	for _, b := range []byte("\033[1;5A") {
+		kp.Write([]byte{b})
+	}
+	select {
+	case ev := <-kp.eventChan:
+		if want := (KeyEvent{Code: KeyUp, Ctrl: true}); ev != want {
+			t.Errorf("event = %+v, want %+v", ev, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event for a sequence written a byte at a time")
+	}
+}
+
+func TestKeyboardParserLoneEscape(t *testing.T) {
+	kp := newKeyboardParser()
+	kp.escTimeout = time.Millisecond
+	kp.Write([]byte("\033"))
+	select {
+	case ev := <-kp.eventChan:
+		if ev != (KeyEvent{Code: KeyESC}) {
+			t.Errorf("event = %+v, want Esc", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event for a lone ESC")
+	}
+
+	// reset drops a pending ESC
+	kp.Write([]byte("\033"))
+	kp.reset()
+	select {
+	case ev := <-kp.eventChan:
+		t.Errorf("event after reset = %+v, want none", ev)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestKeyEventString(t *testing.T) {
+	tests := []struct {
+		ev   KeyEvent
+		want string
+	}{
+		{KeyEvent{Code: KeyChar, Char: 'x'}, "x"},
+		{KeyEvent{Code: KeyChar, Char: ' '}, "Space"},
+		{KeyEvent{Code: KeyChar, Char: 'r', Ctrl: true}, "C-r"},
+		{KeyEvent{Code: KeyChar, Char: 'x', Alt: true}, "M-x"},
+		{KeyEvent{Code: KeyUp, Ctrl: true, Shift: true}, "C-S-Up"},
+		{KeyEvent{Code: KeyLeft, Meta: true}, "Meta-Left"},
+		{KeyEvent{Code: KeyF5}, "F5"},
+		{KeyEvent{Code: KeyF12}, "F12"},
+		{KeyEvent{Code: KeyPageDown}, "PageDown"},
+		{KeyEvent{Code: KeyESC}, "Esc"},
+		{KeyEvent{}, "Unknown"},
+	}
+	for _, tt := range tests {
+		if got := tt.ev.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.ev, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package longterm
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// partialWindow is how long the start of a multi-byte key waits for the
+// rest. The bytes of one key arrive together, so this is kept short to
+// avoid delaying a lone ESC when the escape key is M-x or F1-F12.
+const partialWindow = 50 * time.Millisecond
+
+// magicDetector sits between stdin and the child, watching for the escape
+// key pressed count times, each within window of the last (Ctrl+\ three
+// times within 500ms by default). Presses that might start the sequence
+// are held back: they reach the child only if the window expires or other
+// input arrives first, and are swallowed if the sequence completes. So is
+// the start of a multi-byte key at the end of a read, until the rest
+// arrives. With a count of one it finds single presses in any input.
+type magicDetector struct {
+	mu          sync.Mutex
+	forward     func([]byte) // Delivers input that is not part of the sequence
+	magicKey    []byte
+	window      time.Duration
+	targetCount int
+	pressCount  int    // Presses currently held back
+	partial     []byte // Start of a press split across reads
+	timer       *time.Timer
+	timerGen    int // Identifies the live timer, so a stale expiry is ignored
+}
+
+func newMagicDetector(forward func([]byte), key []byte, count int, window time.Duration) *magicDetector {
+	return &magicDetector{
+		forward:     forward,
+		magicKey:    key,
+		window:      window,
+		targetCount: count,
+	}
+}
+
+// feed processes input bound for the child. When the sequence completes
+// it stops and returns true with the input that followed, which belongs
+// to command mode.
+func (m *magicDetector) feed(p []byte) (rest []byte, triggered bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.partial) > 0 {
+		p = append(m.partial, p...)
+		m.partial = nil
+	}
+	for len(p) > 0 {
+		i := bytes.Index(p, m.magicKey)
+		if i < 0 {
+			// Input may end with the start of another press
+			k := keyPrefix(p, m.magicKey)
+			if k < len(p) {
+				// Other input breaks the sequence
+				m.release()
+				m.forward(p[:len(p)-k])
+			}
+			if k > 0 {
+				m.partial = append([]byte(nil), p[len(p)-k:]...)
+				m.stopTimer()
+				gen := m.timerGen
+				m.timer = time.AfterFunc(min(m.window, partialWindow), func() { m.expire(gen) })
+			}
+			return nil, false
+		}
+		if i > 0 {
+			// Other input breaks the sequence
+			m.release()
+			m.forward(p[:i])
+		}
+		p = p[i+len(m.magicKey):]
+
+		m.pressCount++
+		m.stopTimer()
+		if m.pressCount >= m.targetCount {
+			m.pressCount = 0
+			return p, true
+		}
+		gen := m.timerGen
+		m.timer = time.AfterFunc(m.window, func() { m.expire(gen) })
+	}
+	return nil, false
+}
+
+// flush forwards any held presses, e.g. when input ends
+func (m *magicDetector) flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.release()
+}
+
+// expire gives up on the sequence once the window has passed
+func (m *magicDetector) expire(gen int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if gen == m.timerGen {
+		m.release()
+	}
+}
+
+// release forwards held presses, and any start of one, to the child.
+// Callers hold m.mu.
+func (m *magicDetector) release() {
+	m.stopTimer()
+	held := append(bytes.Repeat(m.magicKey, m.pressCount), m.partial...)
+	m.pressCount, m.partial = 0, nil
+	if len(held) > 0 {
+		m.forward(held)
+	}
+}
+
+// keyPrefix returns the length of the longest proper prefix of key that p
+// ends with
+func keyPrefix(p, key []byte) int {
+	for k := min(len(key)-1, len(p)); k > 0; k-- {
+		if bytes.HasSuffix(p, key[:k]) {
+			return k
+		}
+	}
+	return 0
+}
+
+func (m *magicDetector) stopTimer() {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.timerGen++
+}
@@ -0,0 +1,124 @@
+package longterm
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// forwarded collects what a magic detector forwards
+type forwarded struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (f *forwarded) forward(p []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buf = append(f.buf, p...)
+}
+
+func (f *forwarded) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return string(f.buf)
+}
+
+func TestMagicDetector(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		count     int
+		reads     []string
+		forwarded string // Before any expiry
+		triggered bool
+		rest      string
+	}{
+		{"plain input", `\`, 3, []string{"abc"}, "abc", false, ""},
+		{"triple press", "\x1c", 3, []string{"\x1c\x1c\x1c"}, "", true, ""},
+		{"presses across reads", "\x1c", 3, []string{"\x1c", "\x1c", "\x1c"}, "", true, ""},
+		{"rest after trigger", "\x1c", 3, []string{"a\x1c\x1c\x1cxy"}, "a", true, "xy"},
+		{"input breaks sequence", "\x1c", 3, []string{"\x1c\x1cx\x1c"}, "\x1c\x1cx", false, ""},
+		{"held presses", "\x1c", 3, []string{"\x1c\x1c"}, "", false, ""},
+		{"single press", "\x1bx", 1, []string{"ab\x1bxcd"}, "ab", true, "cd"},
+		{"key split across reads", "\x1bOP", 1, []string{"a\x1bO", "Pb"}, "a", true, "b"},
+		{"key split byte by byte", "\x1bOP", 1, []string{"\x1b", "O", "P"}, "", true, ""},
+		{"split start that isn't the key", "\x1bOP", 1, []string{"\x1bO", "Q"}, "\x1bOQ", false, ""},
+		{"held split start", "\x1bOP", 1, []string{"a\x1b"}, "a", false, ""},
+		{"multi-byte presses", "\x1bx", 2, []string{"\x1bx\x1b", "x!"}, "", true, "!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fw forwarded
+			m := newMagicDetector(fw.forward, []byte(tt.key), tt.count, time.Hour)
+			var rest []byte
+			triggered := false
+			for _, r := range tt.reads {
+				if triggered {
+					t.Fatal("input after the trigger")
+				}
+				rest, triggered = m.feed([]byte(r))
+			}
+			if got := fw.String(); got != tt.forwarded {
+				t.Errorf("forwarded %q, want %q", got, tt.forwarded)
+			}
+			if triggered != tt.triggered || string(rest) != tt.rest {
+				t.Errorf("feed = %q, %v, want %q, %v", rest, triggered, tt.rest, tt.triggered)
+			}
+		})
+	}
+}
+
+func TestMagicDetectorRelease(t *testing.T) {
+	// Held presses reach the child when the window passes
+	var fw forwarded
+	m := newMagicDetector(fw.forward, []byte("\x1c"), 3, 10*time.Millisecond)
+	m.feed([]byte("\x1c\x1c"))
+	deadline := time.Now().Add(time.Second)
+	for fw.String() != "\x1c\x1c" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := fw.String(); got != "\x1c\x1c" {
+		t.Errorf("after the window, forwarded %q", got)
+	}
+
+	// The start of a split key is released too, after a short wait
+	fw = forwarded{}
+	m = newMagicDetector(fw.forward, []byte("\x1bOP"), 1, time.Hour)
+	m.feed([]byte("\x1b"))
+	deadline = time.Now().Add(time.Second)
+	for fw.String() != "\x1b" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := fw.String(); got != "\x1b" {
+		t.Errorf("after the partial window, forwarded %q", got)
+	}
+
+	// flush forwards held presses and partial keys in order
+	fw = forwarded{}
+	m = newMagicDetector(fw.forward, []byte("\x1bx"), 3, time.Hour)
+	m.feed([]byte("\x1bx\x1b"))
+	m.flush()
+	if got := fw.String(); got != "\x1bx\x1b" {
+		t.Errorf("after flush, forwarded %q", got)
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	tests := []struct {
+		p, key string
+		want   int
+	}{
+		{"abc", "x", 0},
+		{"a\x1b", "\x1bOP", 1},
+		{"a\x1bO", "\x1bOP", 2},
+		{"\x1bOP", "\x1bOP", 0}, // Whole keys aren't prefixes
+		{"O", "\x1bOP", 0},
+		{"", "\x1bOP", 0},
+	}
+	for _, tt := range tests {
+		if got := keyPrefix([]byte(tt.p), []byte(tt.key)); got != tt.want {
+			t.Errorf("keyPrefix(%q, %q) = %d, want %d", tt.p, tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package longterm
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// NumericMode tracks numeric input state
+type NumericMode int
+
+const (
+	NumericNone   NumericMode = iota
+	NumericHeight             // 'n' pressed - entering absolute height
+	NumericDelta              // 'd' pressed - entering delta
+	NumericWidth              // 'w' pressed - entering absolute width
+)
+
+// NumericBuffer accumulates numeric input
+type NumericBuffer struct {
+	mode   NumericMode
+	digits []rune
+}
+
+func (nb *NumericBuffer) reset() {
+	nb.mode = NumericNone
+	nb.digits = nil
+}
+
+func (nb *NumericBuffer) append(r rune) {
+	nb.digits = append(nb.digits, r)
+}
+
+func (nb *NumericBuffer) backspace() {
+	if len(nb.digits) > 0 {
+		nb.digits = nb.digits[:len(nb.digits)-1]
+	}
+}
+
+func (nb *NumericBuffer) value() (int, error) {
+	if len(nb.digits) == 0 {
+		return 0, fmt.Errorf("empty input")
+	}
+	s := string(nb.digits)
+	val, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+
+	// Validate range
+	if nb.mode == NumericHeight {
+		if val < 1 || val > 9999 {
+			return 0, fmt.Errorf("height must be 1-9999")
+		}
+	} else if nb.mode == NumericWidth {
+		// Zero passes the real width through
+		if val < 0 || val > 9999 {
+			return 0, fmt.Errorf("width must be 0-9999")
+		}
+	} else if nb.mode == NumericDelta {
+		// Delta requires +/- prefix
+		if len(s) == 0 || (s[0] != '+' && s[0] != '-') {
+			return 0, fmt.Errorf("delta requires +/- prefix")
+		}
+		if val < -9999 || val > 9999 {
+			return 0, fmt.Errorf("delta must be ±1 to ±9999")
+		}
+	}
+	return val, nil
+}
+
+// ParseHeight validates an absolute height the same way numeric entry does
+func ParseHeight(s string) (int, error) {
+	nb := NumericBuffer{mode: NumericHeight, digits: []rune(s)}
+	return nb.value()
+}
+
+// ParseWidth validates an absolute width the same way numeric entry does
+func ParseWidth(s string) (int, error) {
+	nb := NumericBuffer{mode: NumericWidth, digits: []rune(s)}
+	return nb.value()
+}
+
+// ParseDelta validates a signed delta the same way numeric entry does
+func ParseDelta(s string) (int, error) {
+	nb := NumericBuffer{mode: NumericDelta, digits: []rune(s)}
+	return nb.value()
+}
@@ -0,0 +1,20 @@
+package longterm
+
+import (
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioctlInq reads the number of bytes waiting to be read from a terminal
+const ioctlInq = unix.TIOCINQ
+
+// ioctlGetTermios reads a terminal's settings
+const ioctlGetTermios = unix.TCGETS
+
+// setPdeathsig has the kernel send the child SIGTERM if long-term dies
+// without getting to tear the session down, e.g. from SIGKILL
+func setPdeathsig(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+}
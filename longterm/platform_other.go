@@ -0,0 +1,21 @@
+//go:build !linux
+
+package longterm
+
+import (
+	"os/exec"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioctlInq reads the number of bytes waiting to be read from a terminal.
+// It is FIONREAD, _IOR('f', 127, int) on macOS and the BSDs, which x/sys
+// doesn't export for them.
+const ioctlInq = 0x4004667f
+
+// ioctlGetTermios reads a terminal's settings
+const ioctlGetTermios = unix.TIOCGETA
+
+// setPdeathsig does nothing where the kernel has no parent death signal.
+// The PTY hanging up when long-term dies still sends the child SIGHUP.
+func setPdeathsig(cmd *exec.Cmd) {}
@@ -0,0 +1,202 @@
+package longterm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// getMemoryUsage returns memory in MB for the current process
+func getMemoryUsage() float64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return float64(m.Alloc) / 1024 / 1024
+}
+
+// getProcessMemory returns memory in MB for a given PID by reading /proc
+func getProcessMemory(pid int) (float64, error) {
+	statusFile := fmt.Sprintf("/proc/%d/status", pid)
+	data, err := os.ReadFile(statusFile)
+	if err != nil {
+		return 0, err
+	}
+
+	// Look for VmRSS (resident set size) in KB
+	lines := strings.Split(string(data), "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "VmRSS:") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 {
+				var kb int
+				fmt.Sscanf(fields[1], "%d", &kb)
+				return float64(kb) / 1024, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("VmRSS not found")
+}
+
+// resourcePoll is how often the resource figures are sampled
+const resourcePoll = time.Second
+
+// clockTicks is USER_HZ, the unit of the CPU times in /proc/<pid>/stat. It
+// is 100 on every architecture Linux exposes to userspace.
+const clockTicks = 100
+
+// Resources is a sample of what the wrapped process tree and long-term
+// itself are using
+type Resources struct {
+	Tree        bool    `json:"tree"`        // false where /proc can't be read
+	RSS         float64 `json:"rss_mb"`      // Child and descendants, in MB
+	CPU         float64 `json:"cpu_percent"` // Of one CPU, since the last sample
+	Threads     int     `json:"threads"`
+	Descendants int     `json:"descendants"` // Other processes in the PTY's session
+	Heap        float64 `json:"heap_mb"`     // long-term's own heap, in MB
+	Throughput  float64 `json:"pty_bytes_per_sec"`
+}
+
+// resourceMonitor samples Resources at most once per resourcePoll, keeping
+// what it needs to turn counters into rates
+type resourceMonitor struct {
+	mu    sync.Mutex
+	last  Resources
+	at    time.Time
+	ticks map[int]uint64 // CPU time per process at the last sample
+	bytes uint64
+}
+
+// sample returns the resources of the session led by sid, given the
+// total bytes through the PTY so far
+func (m *resourceMonitor) sample(sid int, bytes uint64) Resources {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if !m.at.IsZero() && now.Sub(m.at) < resourcePoll {
+		return m.last
+	}
+
+	r := Resources{Heap: getMemoryUsage()}
+	ticks := make(map[int]uint64)
+	var used uint64
+	for _, p := range sessionProcs(sid) {
+		r.Tree = true
+		r.Threads += p.threads
+		if p.pid != sid {
+			r.Descendants++
+		}
+		if rss, err := getProcessMemory(p.pid); err == nil {
+			r.RSS += rss
+		}
+		ticks[p.pid] = p.ticks
+		// A process new since the last sample used all its time since then
+		if prev := m.ticks[p.pid]; p.ticks >= prev {
+			used += p.ticks - prev
+		}
+	}
+	if !m.at.IsZero() {
+		elapsed := now.Sub(m.at).Seconds()
+		r.CPU = float64(used) / clockTicks / elapsed * 100
+		r.Throughput = float64(bytes-m.bytes) / elapsed
+	}
+
+	m.last, m.at, m.ticks, m.bytes = r, now, ticks, bytes
+	return r
+}
+
+// procStat is the part of /proc/<pid>/stat long-term uses
+type procStat struct {
+	pid     int
+	state   byte // R, S, Z and so on
+	pgrp    int
+	session int
+	ticks   uint64 // User and system CPU time
+	threads int
+}
+
+// readProcStat reads /proc/<pid>/stat. It fails for a process that is
+// gone, or where there is no /proc.
+func readProcStat(pid int) (procStat, bool) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procStat{}, false
+	}
+	// The command name may contain spaces and parentheses, so the fields
+	// are counted from the last ')'
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return procStat{}, false
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 18 || len(fields[0]) != 1 {
+		return procStat{}, false
+	}
+	pgrp, _ := strconv.Atoi(fields[2])
+	session, _ := strconv.Atoi(fields[3])
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	threads, _ := strconv.Atoi(fields[17])
+	return procStat{
+		pid:     pid,
+		state:   fields[0][0],
+		pgrp:    pgrp,
+		session: session,
+		ticks:   utime + stime,
+		threads: threads,
+	}, true
+}
+
+// findProcs walks /proc for the processes that match
+func findProcs(match func(procStat) bool) []procStat {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var procs []procStat
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		// Any that exited since the directory was read are skipped
+		if p, ok := readProcStat(pid); ok && match(p) {
+			procs = append(procs, p)
+		}
+	}
+	return procs
+}
+
+// sessionProcs walks /proc for the processes in session sid
+func sessionProcs(sid int) []procStat {
+	return findProcs(func(p procStat) bool { return p.session == sid })
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	n *atomic.Uint64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	w.n.Add(uint64(len(p)))
+	return len(p), nil
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. "12.3M"
+func formatBytes(n float64) string {
+	units := []string{"B", "K", "M", "G"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f%s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", n, units[i])
+}
@@ -0,0 +1,119 @@
+package longterm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// castHeader is the first line of an asciicast v2 file
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// recorder writes PTY output and resizes as asciicast v2 events
+type recorder struct {
+	mu      sync.Mutex
+	w       io.Writer
+	start   time.Time
+	width   int
+	height  int
+	partial []byte // Incomplete UTF-8 sequence carried to the next write
+	err     error
+}
+
+func newRecorder(w io.Writer, width, height int, command string) (*recorder, error) {
+	r := &recorder{
+		w:      w,
+		start:  time.Now(),
+		width:  width,
+		height: height,
+	}
+	header := castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Command:   command,
+		Env: map[string]string{
+			"SHELL": os.Getenv("SHELL"),
+			"TERM":  os.Getenv("TERM"),
+		},
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(w, "%s\n", data); err != nil {
+		return nil, fmt.Errorf("failed to write recording: %w", err)
+	}
+	return r, nil
+}
+
+// Write records p as an output event. Recording errors are remembered
+// rather than returned so they never interrupt the PTY stream.
+func (r *recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := append(r.partial, p...)
+	r.partial = nil
+
+	// Hold back a trailing incomplete rune so it isn't mangled in JSON
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.partial = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		r.event("o", string(data[:cut]))
+	}
+	return len(p), nil
+}
+
+// resize records an "r" event when the reported size changes
+func (r *recorder) resize(width, height int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if width == r.width && height == r.height {
+		return
+	}
+	r.width, r.height = width, height
+	r.event("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+// event writes a single event line. Callers hold r.mu.
+func (r *recorder) event(kind, data string) {
+	if r.err != nil {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	payload, _ := json.Marshal([]any{json.Number(fmt.Sprintf("%.6f", elapsed)), kind, data})
+	_, r.err = fmt.Fprintf(r.w, "%s\n", payload)
+}
+
+// close flushes any held-back bytes and reports the first write error
+func (r *recorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.partial) > 0 {
+		r.event("o", strings.ToValidUTF8(string(r.partial), "�"))
+		r.partial = nil
+	}
+	return r.err
+}
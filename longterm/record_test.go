@@ -0,0 +1,92 @@
+package longterm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordCast records a short session with a pause and a resize in it
+func recordCast(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "session.cast")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := newRecorder(f, 80, 500, "htop -d 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("one\r\n"))
+	r.Write([]byte("\xe2\x9c")) // A rune split across writes
+	r.Write([]byte("\x93\r\n"))
+	time.Sleep(300 * time.Millisecond)
+	r.resize(80, 500) // Unchanged, so not recorded
+	r.resize(100, 40)
+	r.Write([]byte("two"))
+	if err := r.close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRecordingRoundTrip(t *testing.T) {
+	rec, err := LoadRecording(recordCast(t), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Width != 80 || rec.Height != 500 {
+		t.Errorf("size = %dx%d, want 80x500", rec.Width, rec.Height)
+	}
+
+	want := []Event{{Kind: "o", Data: "one\r\n"}, {Kind: "o", Data: "✓\r\n"}, {Kind: "r", Data: "100x40"}, {Kind: "o", Data: "two"}}
+	if len(rec.Events) != len(want) {
+		t.Fatalf("events = %+v, want %d", rec.Events, len(want))
+	}
+	for i, ev := range rec.Events {
+		if ev.Kind != want[i].Kind || ev.Data != want[i].Data {
+			t.Errorf("event %d = %q %q, want %q %q", i, ev.Kind, ev.Data, want[i].Kind, want[i].Data)
+		}
+		if i > 0 && ev.Time < rec.Events[i-1].Time {
+			t.Errorf("event %d at %.6f is before the one at %.6f", i, ev.Time, rec.Events[i-1].Time)
+		}
+	}
+	if gap := rec.Events[2].Time - rec.Events[1].Time; gap < 0.3 || gap > 1 {
+		t.Errorf("pause recorded as %.3fs, want about 0.3s", gap)
+	}
+}
+
+func TestReplayIdleLimit(t *testing.T) {
+	rec, err := LoadRecording(recordCast(t), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		limit    time.Duration
+		min, max time.Duration
+	}{
+		{"no limit", 0, 300 * time.Millisecond, time.Second},
+		{"limited", 20 * time.Millisecond, 0, 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			start := time.Now()
+			err := Replay(rec, ReplayOptions{IdleLimit: tt.limit, Clamp: true, Stdin: strings.NewReader(""), Stdout: &out})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if took := time.Since(start); took < tt.min || took > tt.max {
+				t.Errorf("replay took %v, want %v to %v", took, tt.min, tt.max)
+			}
+			if got := out.String(); !strings.Contains(got, "one\r\n✓\r\n") || !strings.Contains(got, "two") {
+				t.Errorf("output = %q, want the recorded output", got)
+			}
+		})
+	}
+}
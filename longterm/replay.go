@@ -0,0 +1,480 @@
+package longterm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// Event is a single timed event from a recording
+type Event struct {
+	Time float64 // Seconds since the start of the recording
+	Kind string  // "o" for output, "r" for resize
+	Data string
+}
+
+// Recording is a session loaded from an asciicast or typescript file
+type Recording struct {
+	Width  int // Zero when the format doesn't record a size
+	Height int
+	Events []Event
+}
+
+// LoadRecording reads an asciicast v2 file, or a raw `script` typescript
+// with an optional timing file
+func LoadRecording(path, timingPath string) (*Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var header castHeader
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	if json.Unmarshal(firstLine, &header) == nil && header.Version == 2 {
+		return parseCast(header, data[len(firstLine):])
+	}
+	if header.Version != 0 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	var timing []byte
+	if timingPath != "" {
+		if timing, err = os.ReadFile(timingPath); err != nil {
+			return nil, err
+		}
+	}
+	return parseTypescript(data, timing)
+}
+
+func parseCast(header castHeader, body []byte) (*Recording, error) {
+	rec := &Recording{Width: header.Width, Height: header.Height}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var raw []json.RawMessage
+		if err := json.Unmarshal([]byte(text), &raw); err != nil || len(raw) != 3 {
+			return nil, fmt.Errorf("line %d: malformed event", line+1)
+		}
+		var ev Event
+		if json.Unmarshal(raw[0], &ev.Time) != nil ||
+			json.Unmarshal(raw[1], &ev.Kind) != nil ||
+			json.Unmarshal(raw[2], &ev.Data) != nil {
+			return nil, fmt.Errorf("line %d: malformed event", line+1)
+		}
+		rec.Events = append(rec.Events, ev)
+	}
+	return rec, scanner.Err()
+}
+
+// parseTypescript splits a typescript into output events using a classic
+// ("delay bytes") or advanced ("O delay bytes") timing file. Without
+// timing the whole file is a single event.
+func parseTypescript(data, timing []byte) (*Recording, error) {
+	// Strip the "Script started/done" lines that script(1) adds
+	if bytes.HasPrefix(data, []byte("Script started")) {
+		if _, rest, ok := bytes.Cut(data, []byte("\n")); ok {
+			data = rest
+		}
+	}
+	if i := bytes.LastIndex(data, []byte("\nScript done")); i >= 0 {
+		data = data[:i+1]
+	}
+
+	rec := &Recording{}
+	if timing == nil {
+		rec.Events = []Event{{Time: 0, Kind: "o", Data: string(data)}}
+		return rec, nil
+	}
+
+	var elapsed float64
+	offset := 0
+	for line, text := range strings.Split(string(timing), "\n") {
+		fields := strings.Fields(text)
+		if len(fields) == 3 {
+			// Advanced format: only output entries carry typescript bytes
+			if fields[0] != "O" {
+				continue
+			}
+			fields = fields[1:]
+		}
+		if len(fields) != 2 {
+			continue
+		}
+		delay, err1 := strconv.ParseFloat(fields[0], 64)
+		n, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil || n < 0 {
+			return nil, fmt.Errorf("timing line %d: malformed entry", line+1)
+		}
+		elapsed += delay
+		end := min(offset+n, len(data))
+		rec.Events = append(rec.Events, Event{Time: elapsed, Kind: "o", Data: string(data[offset:end])})
+		offset = end
+	}
+	if offset < len(data) {
+		rec.Events = append(rec.Events, Event{Time: elapsed, Kind: "o", Data: string(data[offset:])})
+	}
+	return rec, nil
+}
+
+// ReplayOptions configures Replay
+type ReplayOptions struct {
+	Speed     float64       // Playback speed multiplier (default 1)
+	IdleLimit time.Duration // Cap on pauses between events (0 = no limit)
+
+	// Clamp rewrites absolute cursor rows so output recorded at a fake
+	// height stays inside the real window. Otherwise the output is rendered
+	// through a virtual screen at the recorded size, following its resizes,
+	// and the window shows the part of it around the cursor.
+	Clamp bool
+
+	Stdin  io.Reader // Defaults to os.Stdin
+	Stdout io.Writer // Defaults to os.Stdout
+}
+
+// Replay plays rec to the terminal. When stdin is a terminal, space
+// pauses, '.' steps one event while paused, '+'/'-' change speed and
+// 'q' or ESC stop playback.
+func Replay(rec *Recording, opts ReplayOptions) error {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	if opts.Stdin == nil {
+		opts.Stdin = os.Stdin
+	}
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+
+	realCols, realRows := rec.Width, rec.Height
+	if f, ok := opts.Stdout.(*os.File); ok {
+		if w, h, err := term.GetSize(int(f.Fd())); err == nil {
+			realCols, realRows = w, h
+		}
+	}
+	if realCols <= 0 || realRows <= 0 {
+		realCols, realRows = 80, 24
+	}
+
+	var out io.Writer
+	var view *replayView
+	if opts.Clamp {
+		out = &clampWriter{w: opts.Stdout, rows: realRows}
+	} else {
+		cols, rows := rec.Width, rec.Height
+		if cols <= 0 || rows <= 0 {
+			cols, rows = realCols, realRows
+		}
+		view = newReplayView(opts.Stdout, cols, rows, realCols, realRows)
+		out = view
+		defer view.finish()
+	}
+
+	// Keyboard controls need raw mode so keys arrive unbuffered
+	var events chan KeyEvent
+	if f, ok := opts.Stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		oldState, err := term.MakeRaw(int(f.Fd()))
+		if err != nil {
+			return fmt.Errorf("failed to set raw mode: %w", err)
+		}
+		defer term.Restore(int(f.Fd()), oldState)
+
+		kbParser := newKeyboardParser()
+		events = kbParser.eventChan
+		done := make(chan struct{})
+		defer close(done)
+		go readKeys(int(f.Fd()), kbParser, done)
+	}
+
+	paused := false
+	prev := 0.0
+	pending := -1               // Event whose deadline is set
+	var deadline time.Time      // When the pending event is due
+	var remaining time.Duration // Time left on the pending event while paused
+
+	// handle applies a key and reports whether to quit or step an event
+	handle := func(key KeyEvent) (quit, step bool) {
+		scale := 1.0
+		switch {
+		case key.Code == KeyESC || (key.Code == KeyChar && key.Char == 'q'):
+			return true, false
+		case key.Code == KeyChar && key.Char == ' ':
+			if paused {
+				deadline = time.Now().Add(remaining)
+			} else {
+				remaining = time.Until(deadline)
+			}
+			paused = !paused
+		case key.Code == KeyChar && key.Char == '.':
+			return false, paused
+		case key.Code == KeyChar && key.Char == '+':
+			scale = 0.5
+		case key.Code == KeyChar && key.Char == '-':
+			scale = 2
+		}
+		if scale != 1 {
+			// Speed up or slow down what is left of the current delay too
+			opts.Speed /= scale
+			if paused {
+				remaining = time.Duration(float64(remaining) * scale)
+			} else {
+				deadline = time.Now().Add(time.Duration(float64(time.Until(deadline)) * scale))
+			}
+		}
+		return false, false
+	}
+
+	for i := 0; i < len(rec.Events); {
+		ev := rec.Events[i]
+		if pending != i {
+			delay := time.Duration((ev.Time - prev) * float64(time.Second))
+			if opts.IdleLimit > 0 && delay > opts.IdleLimit {
+				delay = opts.IdleLimit
+			}
+			delay = time.Duration(float64(delay) / opts.Speed)
+			deadline, remaining, pending = time.Now().Add(delay), delay, i
+		}
+
+		var quit, step bool
+		wait := time.Until(deadline)
+		switch {
+		case paused:
+			if err := view.flush(); err != nil {
+				return err
+			}
+			quit, step = handle(<-events)
+		case wait > 0:
+			// Draw what is due before idling
+			if err := view.flush(); err != nil {
+				return err
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+				step = true
+			case key := <-events:
+				timer.Stop()
+				quit, step = handle(key)
+			}
+		default:
+			select {
+			case key := <-events:
+				quit, step = handle(key)
+			default:
+				step = true
+			}
+		}
+		if quit {
+			return nil
+		}
+		if !step {
+			continue
+		}
+
+		switch ev.Kind {
+		case "o":
+			if _, err := io.WriteString(out, ev.Data); err != nil {
+				return err
+			}
+		case "r":
+			var cols, rows int
+			if _, err := fmt.Sscanf(ev.Data, "%dx%d", &cols, &rows); err == nil && view != nil {
+				view.resize(cols, rows)
+			}
+		}
+		prev = ev.Time
+		i++
+	}
+	return view.flush()
+}
+
+// readKeys feeds the keyboard parser from fd until done is closed
+func readKeys(fd int, kp *keyboardParser, done <-chan struct{}) {
+	buf := make([]byte, 256)
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, 100)
+		if err == unix.EINTR || n == 0 {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		n, err = unix.Read(fd, buf)
+		if err != nil || n == 0 {
+			return
+		}
+		kp.Write(buf[:n])
+	}
+}
+
+// replayView renders a virtual screen at the recorded size to a real
+// window, scrolling to keep the cursor in view
+type replayView struct {
+	w          io.Writer
+	screen     *Screen
+	cols, rows int // Real window
+	top        int // First screen row shown
+	dirty      bool
+}
+
+func newReplayView(w io.Writer, cols, rows, realCols, realRows int) *replayView {
+	v := &replayView{w: w, screen: NewScreen(cols, rows), cols: realCols, rows: realRows}
+	io.WriteString(w, "\033[H\033[2J")
+	return v
+}
+
+func (v *replayView) Write(p []byte) (int, error) {
+	v.dirty = true
+	return v.screen.Write(p)
+}
+
+func (v *replayView) resize(cols, rows int) {
+	v.dirty = true
+	v.screen.Resize(cols, rows)
+}
+
+// flush redraws the window if the screen changed. A nil view has nothing
+// to draw.
+func (v *replayView) flush() error {
+	if v == nil || !v.dirty {
+		return nil
+	}
+	v.dirty = false
+
+	_, rows := v.screen.Size()
+	cx, cy, visible := v.screen.Cursor()
+	h := min(v.rows, rows)
+	if cy < v.top {
+		v.top = cy
+	} else if cy >= v.top+h {
+		v.top = cy - h + 1
+	}
+	v.top = max(0, min(v.top, rows-h))
+
+	var b bytes.Buffer
+	b.WriteString("\033[?25l\033[H")
+	for y := 0; y < h; y++ {
+		line := v.screen.Row(v.top + y)
+		end := min(len(line), v.cols)
+		for end > 0 && line[end-1] == (Cell{}) {
+			end--
+		}
+		style := Style{}
+		b.WriteString(ansiReset)
+		for x := 0; x < end; x++ {
+			c := line[x]
+			if c.Style != style {
+				style = c.Style
+				b.WriteString(sgrSequence(style))
+			}
+			if c.Rune == 0 {
+				b.WriteByte(' ')
+			} else {
+				b.WriteRune(c.Rune)
+			}
+		}
+		b.WriteString(ansiReset + "\033[K")
+		if y < h-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString("\033[J")
+	fmt.Fprintf(&b, "\033[%d;%dH", cy-v.top+1, min(cx, v.cols-1)+1)
+	if visible {
+		b.WriteString("\033[?25h")
+	}
+	_, err := v.w.Write(b.Bytes())
+	return err
+}
+
+// finish leaves the cursor below the last frame
+func (v *replayView) finish() {
+	_, rows := v.screen.Size()
+	fmt.Fprintf(v.w, "\033[%d;1H\r\n\033[?25h", min(v.rows, rows))
+}
+
+// clampWriter limits the row of absolute cursor movements (CUP, HVP, VPA)
+// and scroll regions (DECSTBM) to the real window height
+type clampWriter struct {
+	w     io.Writer
+	rows  int
+	state int // 0=text, 1=saw ESC, 2=in CSI
+	seq   []byte
+}
+
+func (c *clampWriter) Write(p []byte) (int, error) {
+	var out bytes.Buffer
+	for _, b := range p {
+		switch c.state {
+		case 0:
+			if b == 0x1B {
+				c.state = 1
+				continue
+			}
+			out.WriteByte(b)
+		case 1:
+			if b == '[' {
+				c.state = 2
+				c.seq = c.seq[:0]
+				continue
+			}
+			out.WriteByte(0x1B)
+			out.WriteByte(b)
+			c.state = 0
+		case 2:
+			c.seq = append(c.seq, b)
+			if b >= 0x40 && b <= 0x7E {
+				out.WriteString("\033[")
+				out.Write(c.clamp(c.seq))
+				c.state = 0
+			}
+		}
+	}
+	if _, err := c.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// clamp rewrites the row parameters of a CSI sequence (without ESC[)
+func (c *clampWriter) clamp(seq []byte) []byte {
+	final := seq[len(seq)-1]
+	params := strings.Split(string(seq[:len(seq)-1]), ";")
+	switch final {
+	case 'H', 'f', 'd':
+		params[0] = c.clampRow(params[0])
+	case 'r':
+		for i := range params {
+			params[i] = c.clampRow(params[i])
+		}
+	default:
+		return seq
+	}
+	return []byte(strings.Join(params, ";") + string(final))
+}
+
+func (c *clampWriter) clampRow(param string) string {
+	n, err := strconv.Atoi(param)
+	if err != nil || n <= c.rows {
+		return param
+	}
+	return strconv.Itoa(c.rows)
+}
@@ -0,0 +1,58 @@
+package longterm
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ExitReport describes how the wrapped program exited
+type ExitReport struct {
+	ExitCode     int     `json:"exit_code"`             // As a shell reports it: 128+N after signal N
+	Signal       int     `json:"signal,omitempty"`      // Signal that killed the program
+	SignalName   string  `json:"signal_name,omitempty"` // e.g. "SIGSEGV"
+	CoreDumped   bool    `json:"core_dumped"`
+	WallTime     float64 `json:"wall_time_seconds"`
+	MaxRSS       int64   `json:"max_rss_bytes"` // Largest resident set of the program or a waited-for descendant
+	Resizes      int     `json:"resizes"`       // Size changes pushed to the PTY
+	BytesProxied uint64  `json:"bytes_proxied"` // Through the PTY in either direction
+}
+
+// ExitStatus returns the status a shell would report for ps: the exit
+// code, or 128 plus the signal number if the process was killed
+func ExitStatus(ps *os.ProcessState) int {
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ps.ExitCode()
+}
+
+// Report describes how the child exited. It returns false until Wait has
+// returned.
+func (s *Session) Report() (ExitReport, bool) {
+	if s.cmd == nil || s.cmd.ProcessState == nil {
+		return ExitReport{}, false
+	}
+	ps := s.cmd.ProcessState
+	r := ExitReport{
+		ExitCode:     ExitStatus(ps),
+		WallTime:     s.exited.Sub(s.started).Seconds(),
+		Resizes:      int(s.resizes.Load()),
+		BytesProxied: s.ptyBytes.Load(),
+	}
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		r.Signal = int(ws.Signal())
+		r.SignalName = unix.SignalName(ws.Signal())
+		r.CoreDumped = ws.CoreDump()
+	}
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		// ru_maxrss is in kilobytes, except on macOS
+		r.MaxRSS = int64(ru.Maxrss)
+		if runtime.GOOS != "darwin" {
+			r.MaxRSS *= 1024
+		}
+	}
+	return r, true
+}
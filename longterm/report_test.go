@@ -0,0 +1,28 @@
+package longterm
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		script string
+		want   int
+	}{
+		{"exit 0", 0},
+		{"exit 3", 3},
+		{"kill -KILL $$", 137},
+		{"kill -TERM $$", 143},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("sh", "-c", tt.script)
+		cmd.Run()
+		if cmd.ProcessState == nil {
+			t.Fatalf("%q did not run", tt.script)
+		}
+		if got := ExitStatus(cmd.ProcessState); got != tt.want {
+			t.Errorf("ExitStatus(%q) = %d, want %d", tt.script, got, tt.want)
+		}
+	}
+}
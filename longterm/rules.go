@@ -0,0 +1,177 @@
+package longterm
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Rule selects a size policy for commands matching Command (a glob against
+// the program name) or Argv (a glob against the space-joined arguments).
+// The policy is the referenced Profile with the rule's own fields on top,
+// or the real size when Real is set.
+type Rule struct {
+	Command string `toml:"command"`
+	Argv    string `toml:"argv"`
+
+	Real       bool   `toml:"real"`
+	Profile    string `toml:"profile"`
+	Height     *int   `toml:"height"`
+	Delta      *int   `toml:"delta"`
+	Width      *int   `toml:"width"`
+	WidthDelta *int   `toml:"width_delta"`
+}
+
+// Name describes the rule for display
+func (r Rule) Name() string {
+	if r.Command != "" {
+		return r.Command
+	}
+	return r.Argv
+}
+
+// Matches reports whether argv satisfies the rule's patterns. Both
+// patterns must match when both are set.
+func (r Rule) Matches(argv []string) bool {
+	return r.match("", argv)
+}
+
+// match is Matches with an optional process name, such as the kernel's
+// comm for a script whose argv[0] is its interpreter
+func (r Rule) match(name string, argv []string) bool {
+	if len(argv) == 0 || (r.Command == "" && r.Argv == "") {
+		return false
+	}
+	if r.Command != "" && !globMatch(r.Command, filepath.Base(argv[0])) &&
+		(name == "" || !globMatch(r.Command, name)) {
+		return false
+	}
+	if r.Argv != "" && !globMatch(r.Argv, strings.Join(argv, " ")) {
+		return false
+	}
+	return true
+}
+
+// Apply returns opts with the rule's policy layered on top
+func (r Rule) Apply(opts Options, profiles map[string]Profile) Options {
+	if p, ok := profiles[r.Profile]; ok {
+		opts = p.Apply(opts)
+	}
+	opts = Profile{
+		Height:     r.Height,
+		Delta:      r.Delta,
+		Width:      r.Width,
+		WidthDelta: r.WidthDelta,
+	}.Apply(opts)
+	opts.Real = r.Real
+	return opts
+}
+
+// MatchRule returns the first rule matching argv
+func MatchRule(rules []Rule, argv []string) (Rule, bool) {
+	return matchRule(rules, "", argv)
+}
+
+func matchRule(rules []Rule, name string, argv []string) (Rule, bool) {
+	for _, r := range rules {
+		if r.match(name, argv) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// globMatch matches s against a shell-style pattern where '*' also
+// matches '/', so argv patterns can span paths
+func globMatch(pattern, s string) bool {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		case '[':
+			if j := strings.IndexByte(pattern[i:], ']'); j > 0 {
+				class := pattern[i+1 : i+j]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				re.WriteString("[" + class + "]")
+				i += j
+			} else {
+				re.WriteString(`\[`)
+			}
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	matched, err := regexp.MatchString(re.String(), s)
+	return err == nil && matched
+}
+
+// sizePolicy is a rule's size settings, applied on top of the user's
+// settings while the rule is active
+type sizePolicy struct {
+	name string
+	opts Options
+}
+
+// ApplyRules evaluates the session's rules against argv, typically the
+// foreground process. A matching rule overrides the reported size until
+// another evaluation or a manual change; no match clears any override.
+// It returns the name of the active rule, or "".
+func (s *Session) ApplyRules(argv []string) string {
+	return s.applyRules("", argv)
+}
+
+func (s *Session) applyRules(name string, argv []string) string {
+	r, ok := matchRule(s.opts.Rules, name, argv)
+	if !ok {
+		if s.rule.Swap(nil) != nil {
+			s.resize()
+			s.triggerRefresh()
+		}
+		return ""
+	}
+
+	// Rule policies are layered on the launch options, like profiles
+	policy := &sizePolicy{name: r.Name(), opts: r.Apply(s.opts, s.opts.Profiles)}
+	s.rule.Store(policy)
+	s.resize()
+	s.triggerRefresh()
+	return policy.name
+}
+
+// Rule returns the name of the rule currently overriding the size, or ""
+func (s *Session) Rule() string {
+	if p := s.rule.Load(); p != nil {
+		return p.name
+	}
+	return ""
+}
+
+// clearRule drops any rule override
+func (s *Session) clearRule() {
+	s.rule.Store(nil)
+}
+
+// adoptRule copies an active rule's policy into the user's settings and
+// drops the override, so a manual change starts from what was reported
+func (s *Session) adoptRule() {
+	p := s.rule.Swap(nil)
+	if p == nil {
+		return
+	}
+	if p.opts.Real {
+		// Start from the real size as fixed values
+		_, h := s.termSize()
+		s.applyMode(SizeAbsolute, h)
+		s.currentWidth.Store(0)
+		s.currentWidthDelta.Store(0)
+		return
+	}
+	s.applyOptions(p.opts)
+}
@@ -0,0 +1,72 @@
+package longterm
+
+import "testing"
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"less", "less", true},
+		{"less", "lesser", false},
+		{"*", "", true},
+		{"*", "a/b c", true},
+		{"git*", "git-log", true},
+		{"*vim", "nvim", true},
+		{"?vim", "nvim", true},
+		{"?vim", "vim", false},
+		{"git log*", "git log --oneline", true},
+		{"*/bin/*", "/usr/bin/less", true},
+		{"[nv]vim", "nvim", true},
+		{"[nv]vim", "gvim", false},
+		{"[!n]vim", "gvim", true},
+		{"[!n]vim", "nvim", false},
+		{"[a-c]x", "bx", true},
+		{"[", "[", true},
+		{"a[b", "a[b", true},
+		{"a.b", "a.b", true},
+		{"a.b", "axb", false},
+		{"a+(b)", "a+(b)", true},
+		{"^$", "^$", true},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	rules := []Rule{
+		{Command: "less", Real: true},
+		{Argv: "git log*"},
+		{Command: "git", Argv: "* diff*"},
+		{},
+	}
+	tests := []struct {
+		argv []string
+		want int // Index of the matching rule, or -1
+	}{
+		{[]string{"/usr/bin/less", "file"}, 0},
+		{[]string{"git", "log", "-p"}, 1},
+		{[]string{"git", "diff"}, 2},
+		{[]string{"/opt/git", "diff"}, 2},
+		{[]string{"git", "status"}, -1},
+		{[]string{"vim"}, -1},
+		{nil, -1},
+	}
+	for _, tt := range tests {
+		r, ok := MatchRule(rules, tt.argv)
+		switch {
+		case tt.want < 0 && ok:
+			t.Errorf("MatchRule(%q) = %+v, want no match", tt.argv, r)
+		case tt.want >= 0 && (!ok || r != rules[tt.want]):
+			t.Errorf("MatchRule(%q) = %+v, %v, want rule %d", tt.argv, r, ok, tt.want)
+		}
+	}
+
+	// The process name is tried as well as argv[0]
+	if _, ok := matchRule(rules, "less", []string{"/usr/bin/perl", "less.pl"}); !ok {
+		t.Error("matchRule with process name less found no rule")
+	}
+}
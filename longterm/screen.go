@@ -0,0 +1,962 @@
+package longterm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Color is a cell color: ColorDefault, a 256-color palette index made with
+// PaletteColor, or a 24-bit color made with RGBColor
+type Color uint32
+
+const (
+	ColorDefault Color = 0
+	colorPalette Color = 1 << 24
+	colorRGB     Color = 1 << 25
+)
+
+// PaletteColor returns palette entry n (0-255)
+func PaletteColor(n uint8) Color { return colorPalette | Color(n) }
+
+// RGBColor returns a 24-bit color
+func RGBColor(r, g, b uint8) Color {
+	return colorRGB | Color(r)<<16 | Color(g)<<8 | Color(b)
+}
+
+// Palette reports whether c is a palette color and its index
+func (c Color) Palette() (uint8, bool) { return uint8(c), c&colorPalette != 0 }
+
+// RGB returns the components of a 24-bit color, resolving palette colors
+// to the xterm defaults. ok is false for ColorDefault.
+func (c Color) RGB() (r, g, b uint8, ok bool) {
+	switch {
+	case c&colorRGB != 0:
+		return uint8(c >> 16), uint8(c >> 8), uint8(c), true
+	case c&colorPalette != 0:
+		r, g, b = paletteRGB(uint8(c))
+		return r, g, b, true
+	}
+	return 0, 0, 0, false
+}
+
+// paletteRGB converts an xterm 256-color index to RGB
+func paletteRGB(n uint8) (uint8, uint8, uint8) {
+	base := [16][3]uint8{
+		{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+		{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+		{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+		{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+	}
+	switch {
+	case n < 16:
+		return base[n][0], base[n][1], base[n][2]
+	case n < 232:
+		n -= 16
+		level := func(v uint8) uint8 {
+			if v == 0 {
+				return 0
+			}
+			return 55 + v*40
+		}
+		return level(n / 36), level(n / 6 % 6), level(n % 6)
+	default:
+		v := 8 + (n-232)*10
+		return v, v, v
+	}
+}
+
+// Attr is a set of text attributes
+type Attr uint8
+
+const (
+	AttrBold Attr = 1 << iota
+	AttrDim
+	AttrItalic
+	AttrUnderline
+	AttrBlink
+	AttrReverse
+	AttrHidden
+	AttrStrike
+)
+
+// Style is the rendition of a cell
+type Style struct {
+	FG    Color
+	BG    Color
+	Attrs Attr
+}
+
+// Cell is a single character cell. A zero Rune is a blank.
+type Cell struct {
+	Rune  rune
+	Style Style
+}
+
+// Modes are the DEC private and ANSI modes a screen tracks
+type Modes struct {
+	AltScreen      bool `json:"alt_screen"`      // ?1049, ?1047, ?47
+	CursorHidden   bool `json:"cursor_hidden"`   // ?25 reset
+	NoAutoWrap     bool `json:"no_auto_wrap"`    // ?7 reset
+	AppCursor      bool `json:"app_cursor"`      // ?1
+	BracketedPaste bool `json:"bracketed_paste"` // ?2004
+	MouseTracking  int  `json:"mouse_tracking"`  // ?9, ?1000, ?1002 or ?1003; 0 when off
+	MouseEncoding  int  `json:"mouse_encoding"`  // ?1005, ?1006 or ?1015; 0 for X10
+	FocusEvents    bool `json:"focus_events"`    // ?1004
+	Insert         bool `json:"insert"`          // ANSI 4
+	Origin         bool `json:"origin"`          // ?6
+}
+
+// Parser states
+const (
+	stGround = iota
+	stEsc
+	stEscInter
+	stCSI
+	stOSC
+	stString // DCS, SOS, PM, APC: ignored until ST
+	stStringEsc
+)
+
+// savedCursor is the state saved by DECSC and restored by DECRC
+type savedCursor struct {
+	x, y  int
+	style Style
+}
+
+// buffer holds a screen's rows as a ring, so scrolling the whole screen
+// moves its start instead of every row. Rows are allocated on first write.
+type buffer struct {
+	rows  [][]Cell
+	start int
+}
+
+func newBuffer(rows int) *buffer {
+	return &buffer{rows: make([][]Cell, rows)}
+}
+
+// at returns row y, nil if unwritten
+func (b *buffer) at(y int) []Cell {
+	return b.rows[(b.start+y)%len(b.rows)]
+}
+
+func (b *buffer) set(y int, line []Cell) {
+	b.rows[(b.start+y)%len(b.rows)] = line
+}
+
+// rotate shifts every row up by n (down for negative n), wrapping the
+// rows that leave one end around to the other
+func (b *buffer) rotate(n int) {
+	b.start = ((b.start+n)%len(b.rows) + len(b.rows)) % len(b.rows)
+}
+
+// Screen is an in-memory model of a VT100/xterm screen fed by the output
+// of a program. It is safe for concurrent use.
+type Screen struct {
+	mu sync.Mutex
+
+	cols, rows int
+	main, alt  *buffer
+	lines      *buffer // Active buffer: main or alt
+
+	x, y        int
+	wrapPending bool
+	style       Style
+	top, bottom int // Scroll region, inclusive
+	saved       savedCursor
+	altSaved    savedCursor // Cursor saved on entering ?1049
+	modes       Modes
+	shifts      uint64 // Operations that moved existing cells
+
+	// Parser state
+	state   int
+	private byte
+	params  []byte
+	inter   []byte
+	utf8buf []byte
+
+	// reply, if set, receives responses to device status queries
+	reply func([]byte)
+}
+
+// NewScreen returns a blank screen of the given size
+func NewScreen(cols, rows int) *Screen {
+	cols, rows = max(cols, 1), max(rows, 1)
+	s := &Screen{
+		cols:   cols,
+		rows:   rows,
+		main:   newBuffer(rows),
+		alt:    newBuffer(rows),
+		bottom: rows - 1,
+	}
+	s.lines = s.main
+	return s
+}
+
+// SetReply sets the function that receives answers to DSR and DA queries
+func (s *Screen) SetReply(reply func([]byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reply = reply
+}
+
+// Size returns the screen dimensions
+func (s *Screen) Size() (cols, rows int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cols, s.rows
+}
+
+// Cursor returns the cursor position (0-based) and whether it is visible
+func (s *Screen) Cursor() (x, y int, visible bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.x, s.y, !s.modes.CursorHidden
+}
+
+// Pen returns the style applied to newly printed characters
+func (s *Screen) Pen() Style {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.style
+}
+
+// Ground reports whether the parser is between escape sequences and
+// characters, so other output can be interleaved without corrupting them
+func (s *Screen) Ground() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state == stGround && len(s.utf8buf) == 0
+}
+
+// Shifts counts operations that moved existing cells rather than
+// overwriting them: scrolling, insertion and deletion, and switching
+// between the main and alternate buffers
+func (s *Screen) Shifts() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shifts
+}
+
+// Modes returns the current terminal modes
+func (s *Screen) Modes() Modes {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.modes
+}
+
+// ScrollRegion returns the first and last rows of the scroll region
+func (s *Screen) ScrollRegion() (top, bottom int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.top, s.bottom
+}
+
+// Cell returns the cell at column x, row y of the active buffer
+func (s *Screen) Cell(x, y int) Cell {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if y < 0 || y >= s.rows || x < 0 || x >= s.cols || s.lines.at(y) == nil {
+		return Cell{}
+	}
+	return s.lines.at(y)[x]
+}
+
+// Lines returns a copy of the active buffer. Unwritten rows are nil.
+func (s *Screen) Lines() [][]Cell {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([][]Cell, s.rows)
+	for i := range out {
+		if line := s.lines.at(i); line != nil {
+			out[i] = append([]Cell(nil), line...)
+		}
+	}
+	return out
+}
+
+// Row returns a copy of row y of the active buffer, or nil if the row is
+// unwritten or out of range
+func (s *Screen) Row(y int) []Cell {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if y < 0 || y >= s.rows || s.lines.at(y) == nil {
+		return nil
+	}
+	return append([]Cell(nil), s.lines.at(y)...)
+}
+
+// Text returns the active buffer as plain text, with trailing spaces on
+// each line and trailing blank lines removed
+func (s *Screen) Text() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	blank := 0
+	for y := 0; y < s.rows; y++ {
+		text := strings.TrimRight(lineText(s.lines.at(y)), " ")
+		if text == "" {
+			blank++
+			continue
+		}
+		for ; blank > 0; blank-- {
+			b.WriteByte('\n')
+		}
+		b.WriteString(text)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func lineText(line []Cell) string {
+	var b strings.Builder
+	for _, c := range line {
+		if c.Rune == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteRune(c.Rune)
+		}
+	}
+	return b.String()
+}
+
+// Resize changes the screen size. Rows that no longer fit above the
+// cursor are dropped from the top.
+func (s *Screen) Resize(cols, rows int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cols, rows = max(cols, 1), max(rows, 1)
+	if cols == s.cols && rows == s.rows {
+		return
+	}
+
+	drop := 0
+	if s.y >= rows {
+		drop = s.y - rows + 1
+	}
+	resizeBuf := func(buf *buffer) *buffer {
+		out := newBuffer(rows)
+		for i := 0; i < rows && drop+i < s.rows; i++ {
+			if old := buf.at(drop + i); old != nil {
+				line := make([]Cell, cols)
+				copy(line, old)
+				out.set(i, line)
+			}
+		}
+		return out
+	}
+	s.main = resizeBuf(s.main)
+	s.alt = resizeBuf(s.alt)
+	if s.modes.AltScreen {
+		s.lines = s.alt
+	} else {
+		s.lines = s.main
+	}
+
+	s.cols, s.rows = cols, rows
+	s.y -= drop
+	s.x = min(s.x, cols-1)
+	s.y = min(s.y, rows-1)
+	s.top, s.bottom = 0, rows-1
+	s.wrapPending = false
+}
+
+// Write feeds program output into the screen
+func (s *Screen) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range p {
+		s.process(b)
+	}
+	return len(p), nil
+}
+
+// process advances the parser by one byte. Callers hold s.mu.
+func (s *Screen) process(b byte) {
+	// C0 controls act in every state except inside strings
+	if b < 0x20 && s.state != stOSC && s.state != stString && s.state != stStringEsc {
+		s.control(b)
+		return
+	}
+
+	switch s.state {
+	case stGround:
+		s.ground(b)
+
+	case stEsc:
+		switch {
+		case b == '[':
+			s.state = stCSI
+			s.private = 0
+			s.params = s.params[:0]
+			s.inter = s.inter[:0]
+		case b == ']':
+			s.state = stOSC
+		case b == 'P' || b == 'X' || b == '^' || b == '_':
+			s.state = stString
+		case b >= 0x20 && b <= 0x2F:
+			s.inter = append(s.inter[:0], b)
+			s.state = stEscInter
+		default:
+			s.state = stGround
+			s.escape(b)
+		}
+
+	case stEscInter:
+		// Charset designations and the like: consume the final byte
+		if b >= 0x30 && b <= 0x7E {
+			if len(s.inter) == 1 && s.inter[0] == '#' && b == '8' {
+				s.alignmentTest()
+			}
+			s.state = stGround
+		}
+
+	case stCSI:
+		switch {
+		case b >= '<' && b <= '?' && len(s.params) == 0 && s.private == 0:
+			s.private = b
+		case (b >= '0' && b <= '9') || b == ';' || b == ':':
+			s.params = append(s.params, b)
+		case b >= 0x20 && b <= 0x2F:
+			s.inter = append(s.inter, b)
+		case b >= 0x40 && b <= 0x7E:
+			s.state = stGround
+			s.csi(b)
+		default:
+			s.state = stGround
+		}
+
+	case stOSC, stString:
+		switch b {
+		case 0x07:
+			s.state = stGround
+		case 0x1B:
+			s.state = stStringEsc
+		}
+
+	case stStringEsc:
+		// ESC \ terminates the string; anything else is ignored
+		s.state = stString
+		if b == '\\' {
+			s.state = stGround
+		}
+	}
+}
+
+// control handles a C0 control character
+func (s *Screen) control(b byte) {
+	switch b {
+	case 0x07: // BEL
+	case 0x08: // BS
+		if s.x > 0 {
+			s.x--
+		}
+		s.wrapPending = false
+	case 0x09: // HT
+		s.x = min((s.x/8+1)*8, s.cols-1)
+		s.wrapPending = false
+	case 0x0A, 0x0B, 0x0C: // LF, VT, FF
+		s.lineFeed()
+	case 0x0D: // CR
+		s.x = 0
+		s.wrapPending = false
+	case 0x18, 0x1A: // CAN, SUB
+		s.state = stGround
+	case 0x1B: // ESC
+		s.state = stEsc
+		s.inter = s.inter[:0]
+	}
+}
+
+// ground handles printable bytes, decoding UTF-8
+func (s *Screen) ground(b byte) {
+	if b == 0x7F {
+		return
+	}
+	if b < 0x80 {
+		if len(s.utf8buf) > 0 {
+			// Truncated sequence
+			s.utf8buf = s.utf8buf[:0]
+			s.print(utf8.RuneError)
+		}
+		s.print(rune(b))
+		return
+	}
+	s.utf8buf = append(s.utf8buf, b)
+	if !utf8.FullRune(s.utf8buf) {
+		if len(s.utf8buf) < utf8.UTFMax {
+			return
+		}
+	}
+	r, _ := utf8.DecodeRune(s.utf8buf)
+	s.utf8buf = s.utf8buf[:0]
+	s.print(r)
+}
+
+// print puts r at the cursor and advances it
+func (s *Screen) print(r rune) {
+	if s.wrapPending {
+		s.x = 0
+		s.lineFeed()
+	}
+	line := s.line(s.y)
+	if s.modes.Insert {
+		copy(line[s.x+1:], line[s.x:])
+		s.shifts++
+	}
+	line[s.x] = Cell{Rune: r, Style: s.style}
+	if s.x == s.cols-1 {
+		s.wrapPending = !s.modes.NoAutoWrap
+	} else {
+		s.x++
+	}
+}
+
+// line returns row y of the active buffer, allocating it if needed
+func (s *Screen) line(y int) []Cell {
+	line := s.lines.at(y)
+	if line == nil {
+		line = make([]Cell, s.cols)
+		s.lines.set(y, line)
+	}
+	return line
+}
+
+// blankLine returns a new row erased with the current background
+func (s *Screen) blankLine() []Cell {
+	if s.style.BG == ColorDefault {
+		return nil
+	}
+	line := make([]Cell, s.cols)
+	s.erase(line, 0, s.cols)
+	return line
+}
+
+// erase blanks line[from:to] with the current background
+func (s *Screen) erase(line []Cell, from, to int) {
+	blank := Cell{Style: Style{BG: s.style.BG}}
+	for i := max(from, 0); i < to && i < len(line); i++ {
+		line[i] = blank
+	}
+}
+
+func (s *Screen) lineFeed() {
+	s.wrapPending = false
+	if s.y == s.bottom {
+		s.scrollUp(1)
+	} else if s.y < s.rows-1 {
+		s.y++
+	}
+}
+
+func (s *Screen) reverseIndex() {
+	s.wrapPending = false
+	if s.y == s.top {
+		s.scrollDown(1)
+	} else if s.y > 0 {
+		s.y--
+	}
+}
+
+// scrollUp moves the scroll region up n lines. Scrolling the whole screen
+// only rotates the ring.
+func (s *Screen) scrollUp(n int) {
+	n = min(n, s.bottom-s.top+1)
+	if s.top == 0 && s.bottom == s.rows-1 {
+		s.lines.rotate(n)
+	} else {
+		for y := s.top; y <= s.bottom-n; y++ {
+			s.lines.set(y, s.lines.at(y+n))
+		}
+	}
+	s.shifts++
+	for y := s.bottom - n + 1; y <= s.bottom; y++ {
+		s.lines.set(y, s.blankLine())
+	}
+}
+
+// scrollDown moves the scroll region down n lines
+func (s *Screen) scrollDown(n int) {
+	n = min(n, s.bottom-s.top+1)
+	if s.top == 0 && s.bottom == s.rows-1 {
+		s.lines.rotate(-n)
+	} else {
+		for y := s.bottom; y >= s.top+n; y-- {
+			s.lines.set(y, s.lines.at(y-n))
+		}
+	}
+	s.shifts++
+	for y := s.top; y < s.top+n; y++ {
+		s.lines.set(y, s.blankLine())
+	}
+}
+
+// escape handles ESC followed by a final byte
+func (s *Screen) escape(b byte) {
+	switch b {
+	case '7': // DECSC
+		s.saved = savedCursor{s.x, s.y, s.style}
+	case '8': // DECRC
+		s.restoreCursor(s.saved)
+	case 'D': // IND
+		s.lineFeed()
+	case 'E': // NEL
+		s.x = 0
+		s.lineFeed()
+	case 'M': // RI
+		s.reverseIndex()
+	case 'c': // RIS
+		s.reset()
+	}
+}
+
+// reset returns the screen to its initial state (RIS)
+func (s *Screen) reset() {
+	s.main = newBuffer(s.rows)
+	s.alt = newBuffer(s.rows)
+	s.lines = s.main
+	s.x, s.y, s.wrapPending = 0, 0, false
+	s.style = Style{}
+	s.top, s.bottom = 0, s.rows-1
+	s.saved, s.altSaved = savedCursor{}, savedCursor{}
+	s.modes = Modes{}
+	s.shifts++
+}
+
+func (s *Screen) restoreCursor(c savedCursor) {
+	s.x = min(c.x, s.cols-1)
+	s.y = min(c.y, s.rows-1)
+	s.style = c.style
+	s.wrapPending = false
+}
+
+// alignmentTest fills the screen with 'E' (DECALN)
+func (s *Screen) alignmentTest() {
+	for y := 0; y < s.rows; y++ {
+		line := s.line(y)
+		for x := range line {
+			line[x] = Cell{Rune: 'E'}
+		}
+	}
+	s.x, s.y = 0, 0
+}
+
+// csiParams parses the collected parameters; empty ones are 0.
+// Sub-parameters separated by ':' are flattened, which is sufficient for
+// SGR colors once the ITU color space slot of "38:2:cs:r:g:b" is dropped.
+func (s *Screen) csiParams() []int {
+	if len(s.params) == 0 {
+		return nil
+	}
+	var out []int
+	for _, field := range strings.Split(string(s.params), ";") {
+		subs := strings.Split(field, ":")
+		if len(subs) == 6 && subs[1] == "2" {
+			subs = append(subs[:2], subs[3:]...)
+		}
+		for _, sub := range subs {
+			n, _ := strconv.Atoi(sub)
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// csi dispatches a complete control sequence
+func (s *Screen) csi(final byte) {
+	params := s.csiParams()
+	arg := func(i, def int) int {
+		if i < len(params) && params[i] != 0 {
+			return params[i]
+		}
+		return def
+	}
+
+	if len(s.inter) > 0 {
+		// DECSCUSR and other intermediate sequences don't affect the grid
+		return
+	}
+
+	switch s.private {
+	case '?':
+		switch final {
+		case 'h', 'l':
+			for _, p := range params {
+				s.setPrivateMode(p, final == 'h')
+			}
+		}
+		return
+	case '>':
+		if final == 'c' {
+			s.send("\033[>0;0;0c")
+		}
+		return
+	case 0:
+	default:
+		return
+	}
+
+	switch final {
+	case '@': // ICH
+		line := s.line(s.y)
+		n := min(arg(0, 1), s.cols-s.x)
+		copy(line[s.x+n:], line[s.x:])
+		s.erase(line, s.x, s.x+n)
+		s.shifts++
+	case 'A': // CUU
+		s.moveTo(s.x, max(s.y-arg(0, 1), s.top))
+	case 'B', 'e': // CUD, VPR
+		s.moveTo(s.x, min(s.y+arg(0, 1), s.bottom))
+	case 'C', 'a': // CUF, HPR
+		s.moveTo(s.x+arg(0, 1), s.y)
+	case 'D': // CUB
+		s.moveTo(s.x-arg(0, 1), s.y)
+	case 'E': // CNL
+		s.moveTo(0, min(s.y+arg(0, 1), s.bottom))
+	case 'F': // CPL
+		s.moveTo(0, max(s.y-arg(0, 1), s.top))
+	case 'G', '`': // CHA, HPA
+		s.moveTo(arg(0, 1)-1, s.y)
+	case 'H', 'f': // CUP, HVP
+		row := arg(0, 1) - 1
+		if s.modes.Origin {
+			row += s.top
+		}
+		s.moveTo(arg(1, 1)-1, row)
+	case 'd': // VPA
+		s.moveTo(s.x, arg(0, 1)-1)
+	case 'J': // ED
+		s.eraseDisplay(arg(0, 0))
+	case 'K': // EL
+		line := s.line(s.y)
+		switch arg(0, 0) {
+		case 0:
+			s.erase(line, s.x, s.cols)
+		case 1:
+			s.erase(line, 0, s.x+1)
+		case 2:
+			s.erase(line, 0, s.cols)
+		}
+	case 'L': // IL
+		if s.y >= s.top && s.y <= s.bottom {
+			top := s.top
+			s.top = s.y
+			s.scrollDown(arg(0, 1))
+			s.top = top
+			s.x = 0
+		}
+	case 'M': // DL
+		if s.y >= s.top && s.y <= s.bottom {
+			top := s.top
+			s.top = s.y
+			s.scrollUp(arg(0, 1))
+			s.top = top
+			s.x = 0
+		}
+	case 'P': // DCH
+		line := s.line(s.y)
+		n := min(arg(0, 1), s.cols-s.x)
+		copy(line[s.x:], line[s.x+n:])
+		s.erase(line, s.cols-n, s.cols)
+		s.shifts++
+	case 'S': // SU
+		s.scrollUp(arg(0, 1))
+	case 'T': // SD
+		s.scrollDown(arg(0, 1))
+	case 'X': // ECH
+		s.erase(s.line(s.y), s.x, s.x+arg(0, 1))
+	case 'c': // DA
+		s.send("\033[?62;22c")
+	case 'h', 'l': // SM, RM
+		for _, p := range params {
+			if p == 4 {
+				s.modes.Insert = final == 'h'
+			}
+		}
+	case 'm': // SGR
+		s.sgr(params)
+	case 'n': // DSR
+		switch arg(0, 0) {
+		case 5:
+			s.send("\033[0n")
+		case 6:
+			s.send(fmt.Sprintf("\033[%d;%dR", s.y+1, s.x+1))
+		}
+	case 'r': // DECSTBM
+		top, bottom := arg(0, 1)-1, arg(1, s.rows)-1
+		if top < bottom && bottom < s.rows {
+			s.top, s.bottom = top, bottom
+			s.moveTo(0, 0)
+			if s.modes.Origin {
+				s.y = s.top
+			}
+		}
+	case 's': // SCP
+		s.saved = savedCursor{s.x, s.y, s.style}
+	case 'u': // RCP
+		s.restoreCursor(s.saved)
+	}
+}
+
+// moveTo positions the cursor, clamped to the screen
+func (s *Screen) moveTo(x, y int) {
+	s.x = min(max(x, 0), s.cols-1)
+	s.y = min(max(y, 0), s.rows-1)
+	s.wrapPending = false
+}
+
+func (s *Screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		s.erase(s.line(s.y), s.x, s.cols)
+		for y := s.y + 1; y < s.rows; y++ {
+			s.lines.set(y, s.blankLine())
+		}
+	case 1:
+		for y := 0; y < s.y; y++ {
+			s.lines.set(y, s.blankLine())
+		}
+		s.erase(s.line(s.y), 0, s.x+1)
+	case 2, 3:
+		for y := 0; y < s.rows; y++ {
+			s.lines.set(y, s.blankLine())
+		}
+	}
+}
+
+// setPrivateMode applies DECSET/DECRST
+func (s *Screen) setPrivateMode(mode int, on bool) {
+	switch mode {
+	case 1:
+		s.modes.AppCursor = on
+	case 6:
+		s.modes.Origin = on
+		s.moveTo(0, 0)
+		if on {
+			s.y = s.top
+		}
+	case 7:
+		s.modes.NoAutoWrap = !on
+	case 25:
+		s.modes.CursorHidden = !on
+	case 47, 1047, 1049:
+		if on == s.modes.AltScreen {
+			return
+		}
+		if on {
+			if mode == 1049 {
+				s.altSaved = savedCursor{s.x, s.y, s.style}
+			}
+			s.alt = newBuffer(s.rows)
+			s.lines = s.alt
+		} else {
+			s.lines = s.main
+			if mode == 1049 {
+				s.restoreCursor(s.altSaved)
+			}
+		}
+		s.modes.AltScreen = on
+		s.shifts++
+	case 2004:
+		s.modes.BracketedPaste = on
+	case 9, 1000, 1002, 1003:
+		// Tracking modes replace each other; resetting any turns it off
+		s.modes.MouseTracking = 0
+		if on {
+			s.modes.MouseTracking = mode
+		}
+	case 1004:
+		s.modes.FocusEvents = on
+	case 1005, 1006, 1015:
+		s.modes.MouseEncoding = 0
+		if on {
+			s.modes.MouseEncoding = mode
+		}
+	}
+}
+
+// sgr applies Select Graphic Rendition parameters
+func (s *Screen) sgr(params []int) {
+	if len(params) == 0 {
+		params = []int{0}
+	}
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		switch {
+		case p == 0:
+			s.style = Style{}
+		case p == 1:
+			s.style.Attrs |= AttrBold
+		case p == 2:
+			s.style.Attrs |= AttrDim
+		case p == 3:
+			s.style.Attrs |= AttrItalic
+		case p == 4 || p == 21:
+			s.style.Attrs |= AttrUnderline
+		case p == 5 || p == 6:
+			s.style.Attrs |= AttrBlink
+		case p == 7:
+			s.style.Attrs |= AttrReverse
+		case p == 8:
+			s.style.Attrs |= AttrHidden
+		case p == 9:
+			s.style.Attrs |= AttrStrike
+		case p == 22:
+			s.style.Attrs &^= AttrBold | AttrDim
+		case p == 23:
+			s.style.Attrs &^= AttrItalic
+		case p == 24:
+			s.style.Attrs &^= AttrUnderline
+		case p == 25:
+			s.style.Attrs &^= AttrBlink
+		case p == 27:
+			s.style.Attrs &^= AttrReverse
+		case p == 28:
+			s.style.Attrs &^= AttrHidden
+		case p == 29:
+			s.style.Attrs &^= AttrStrike
+		case p >= 30 && p <= 37:
+			s.style.FG = PaletteColor(uint8(p - 30))
+		case p == 38 || p == 48:
+			c, used := extendedColor(params[i+1:])
+			i += used
+			if p == 38 {
+				s.style.FG = c
+			} else {
+				s.style.BG = c
+			}
+		case p == 39:
+			s.style.FG = ColorDefault
+		case p >= 40 && p <= 47:
+			s.style.BG = PaletteColor(uint8(p - 40))
+		case p == 49:
+			s.style.BG = ColorDefault
+		case p >= 90 && p <= 97:
+			s.style.FG = PaletteColor(uint8(p - 90 + 8))
+		case p >= 100 && p <= 107:
+			s.style.BG = PaletteColor(uint8(p - 100 + 8))
+		}
+	}
+}
+
+// extendedColor parses the arguments after SGR 38/48, returning the color
+// and how many parameters it consumed
+func extendedColor(params []int) (Color, int) {
+	if len(params) >= 2 && params[0] == 5 {
+		return PaletteColor(uint8(params[1])), 2
+	}
+	if len(params) >= 4 && params[0] == 2 {
+		return RGBColor(uint8(params[1]), uint8(params[2]), uint8(params[3])), 4
+	}
+	return ColorDefault, len(params)
+}
+
+// send delivers a query response. Callers hold s.mu.
+func (s *Screen) send(reply string) {
+	if s.reply != nil {
+		s.reply([]byte(reply))
+	}
+}
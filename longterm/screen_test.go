@@ -0,0 +1,364 @@
+package longterm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScreenText(t *testing.T) {
+	tests := []struct {
+		name       string
+		cols, rows int
+		input      string
+		want       string
+		x, y       int
+	}{
+		{"plain", 10, 3, "hello", "hello\n", 5, 0},
+		{"crlf", 10, 3, "a\r\nb", "a\nb\n", 1, 1},
+		{"lf keeps column", 10, 3, "ab\ncd", "ab\n  cd\n", 4, 1},
+		{"backspace", 10, 3, "abc\bX", "abX\n", 3, 0},
+		{"tab", 20, 3, "a\tb", "a       b\n", 9, 0},
+		{"tab stops at margin", 10, 3, "\t\t\tx", "         x\n", 9, 0},
+		{"autowrap", 4, 3, "abcdef", "abcd\nef\n", 2, 1},
+		{"wrap pending at margin", 4, 3, "abcd", "abcd\n", 3, 0},
+		{"no autowrap", 4, 3, "\033[?7labcdef", "abcf\n", 3, 0},
+		{"scrolls at bottom", 5, 2, "1\r\n2\r\n3", "2\n3\n", 1, 1},
+		{"cup", 10, 5, "\033[3;4Hx", "\n\n   x\n", 4, 2},
+		{"cup clamps", 5, 3, "\033[99;99Hx", "\n\n    x\n", 4, 2},
+		{"cuu cud cuf cub", 10, 5, "\033[3;3H\033[Aa\033[2Bb\033[3Cc\033[5Dd", "\n  a\n\n   d   c\n", 4, 3},
+		{"cha and vpa", 10, 5, "\033[5G\033[3dx", "\n\n    x\n", 5, 2},
+		{"cnl and cpl", 10, 5, "ab\033[2Ec\033[Fd", "ab\nd\nc\n", 1, 1},
+		{"el to end", 10, 2, "abcdef\033[3G\033[K", "ab\n", 2, 0},
+		{"el to start", 10, 2, "abcdef\033[3G\033[1K", "   def\n", 2, 0},
+		{"el whole line", 10, 2, "abcdef\033[2K", "", 6, 0},
+		{"ed below", 10, 3, "aaa\r\nbbb\r\nccc\033[2;2H\033[J", "aaa\nb\n", 1, 1},
+		{"ed above", 10, 3, "aaa\r\nbbb\r\nccc\033[2;2H\033[1J", "\n  b\nccc\n", 1, 1},
+		{"ed all", 10, 3, "aaa\r\nbbb\033[2J", "", 3, 1},
+		{"ech", 10, 2, "abcdef\033[2G\033[3X", "a   ef\n", 1, 0},
+		{"ich", 10, 2, "abcdef\033[2G\033[2@", "a  bcdef\n", 1, 0},
+		{"dch", 10, 2, "abcdef\033[2G\033[2P", "adef\n", 1, 0},
+		{"insert mode", 10, 2, "abc\033[2G\033[4hX\033[4l", "aXbc\n", 2, 0},
+		{"il", 10, 4, "1\r\n2\r\n3\033[2;1H\033[L", "1\n\n2\n3\n", 0, 1},
+		{"dl", 10, 4, "1\r\n2\r\n3\033[1;1H\033[M", "2\n3\n", 0, 0},
+		{"su and sd", 10, 3, "1\r\n2\r\n3\033[S", "2\n3\n", 1, 2},
+		{"sd", 10, 3, "1\r\n2\r\n3\033[T", "\n1\n2\n", 1, 2},
+		{"reverse index at top", 10, 3, "1\r\n2\033[H\033M", "\n1\n2\n", 0, 0},
+		{"nel", 10, 3, "ab\033Ecd", "ab\ncd\n", 2, 1},
+		{"save and restore", 10, 3, "ab\0337\033[3;5Hx\0338c", "abc\n\n    x\n", 3, 0},
+		{"scp and rcp", 10, 3, "ab\033[s\033[3;5Hx\033[uc", "abc\n\n    x\n", 3, 0},
+		{"decaln", 3, 2, "\033#8", "EEE\nEEE\n", 0, 0},
+		{"ris", 10, 3, "abc\033c", "", 0, 0},
+		{"utf8", 10, 2, "h\xc3\xa9llo \xe2\x9c\x93", "héllo ✓\n", 7, 0},
+		{"truncated utf8", 10, 2, "\xc3x", "�x\n", 2, 0},
+		{"osc ignored", 10, 2, "\033]0;title\007ok", "ok\n", 2, 0},
+		{"osc st ignored", 10, 2, "\033]0;title\033\\ok", "ok\n", 2, 0},
+		{"dcs ignored", 10, 2, "\033Pq#0\033\\ok", "ok\n", 2, 0},
+		{"charset designation", 10, 2, "\033(Bok", "ok\n", 2, 0},
+		{"can aborts sequence", 10, 2, "\033[3\030ok", "ok\n", 2, 0},
+		{"del ignored", 10, 2, "a\x7fb", "ab\n", 2, 0},
+		{"intermediate csi ignored", 10, 2, "\033[2 qok", "ok\n", 2, 0},
+		{"trailing spaces trimmed", 10, 3, "a   \r\n\r\nb", "a\n\nb\n", 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := NewScreen(tt.cols, tt.rows)
+			sc.Write([]byte(tt.input))
+			if got := sc.Text(); got != tt.want {
+				t.Errorf("Text() = %q, want %q", got, tt.want)
+			}
+			if x, y, _ := sc.Cursor(); x != tt.x || y != tt.y {
+				t.Errorf("Cursor() = %d,%d, want %d,%d", x, y, tt.x, tt.y)
+			}
+		})
+	}
+}
+
+func TestScreenSplitWrites(t *testing.T) {
+	input := "\033[1;31mred\033[0m \xe2\x9c\x93\033[2;3Hx"
+	whole := NewScreen(10, 3)
+	whole.Write([]byte(input))
+
+	split := NewScreen(10, 3)
+	for i := 0; i < len(input); i++ {
+		split.Write([]byte{input[i]})
+	}
+	if got, want := split.Text(), whole.Text(); got != want {
+		t.Errorf("byte-at-a-time Text() = %q, want %q", got, want)
+	}
+	if got, want := split.Cell(0, 0), whole.Cell(0, 0); got != want {
+		t.Errorf("byte-at-a-time Cell(0, 0) = %+v, want %+v", got, want)
+	}
+}
+
+func TestScreenScrollRegion(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		want        string
+		top, bottom int
+	}{
+		{"lines scroll inside region", "\033[2;3r\033[1;1H1\r\n2\r\n3\r\n4", "1\n3\n4\n", 1, 2},
+		{"su inside region", "1\r\n2\r\n3\r\n4\033[2;3r\033[S", "1\n3\n\n4\n", 1, 2},
+		{"il inside region", "1\r\n2\r\n3\r\n4\033[2;3r\033[2;1H\033[L", "1\n\n2\n4\n", 1, 2},
+		{"dl inside region", "1\r\n2\r\n3\r\n4\033[2;3r\033[2;1H\033[M", "1\n3\n\n4\n", 1, 2},
+		{"il outside region", "1\r\n2\r\n3\r\n4\033[2;3r\033[4;1H\033[L", "1\n2\n3\n4\n", 1, 2},
+		{"ri at region top", "1\r\n2\r\n3\r\n4\033[2;3r\033[2;1H\033M", "1\n\n2\n4\n", 1, 2},
+		{"invalid region ignored", "\033[3;2r", "", 0, 3},
+		{"reset region", "\033[2;3r\033[r", "", 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := NewScreen(10, 4)
+			sc.Write([]byte(tt.input))
+			if got := sc.Text(); got != tt.want {
+				t.Errorf("Text() = %q, want %q", got, tt.want)
+			}
+			if top, bottom := sc.ScrollRegion(); top != tt.top || bottom != tt.bottom {
+				t.Errorf("ScrollRegion() = %d,%d, want %d,%d", top, bottom, tt.top, tt.bottom)
+			}
+		})
+	}
+}
+
+func TestScreenOriginMode(t *testing.T) {
+	sc := NewScreen(10, 5)
+	sc.Write([]byte("\033[2;4r\033[?6h\033[1;1Ha"))
+	if got, want := sc.Text(), "\na\n"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+	sc.Write([]byte("\033[?6l"))
+	if x, y, _ := sc.Cursor(); x != 0 || y != 0 {
+		t.Errorf("Cursor() after ?6l = %d,%d, want 0,0", x, y)
+	}
+}
+
+func TestScreenSGR(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Style
+	}{
+		{"reset", "\033[1;31m\033[m", Style{}},
+		{"attrs", "\033[1;2;3;4;5;7;8;9m", Style{Attrs: AttrBold | AttrDim | AttrItalic | AttrUnderline | AttrBlink | AttrReverse | AttrHidden | AttrStrike}},
+		{"attrs off", "\033[1;3;4;5;7;8;9m\033[22;23;24;25;27;28;29m", Style{}},
+		{"double underline", "\033[21m", Style{Attrs: AttrUnderline}},
+		{"basic colors", "\033[31;42m", Style{FG: PaletteColor(1), BG: PaletteColor(2)}},
+		{"bright colors", "\033[91;102m", Style{FG: PaletteColor(9), BG: PaletteColor(10)}},
+		{"default colors", "\033[31;42m\033[39;49m", Style{}},
+		{"256 colors", "\033[38;5;200;48;5;17m", Style{FG: PaletteColor(200), BG: PaletteColor(17)}},
+		{"truecolor", "\033[38;2;1;2;3;48;2;4;5;6m", Style{FG: RGBColor(1, 2, 3), BG: RGBColor(4, 5, 6)}},
+		{"colon truecolor", "\033[38:2::1:2:3m", Style{FG: RGBColor(1, 2, 3)}},
+		{"colon 256", "\033[38:5:99m", Style{FG: PaletteColor(99)}},
+		{"truncated extended", "\033[1;38;5m", Style{Attrs: AttrBold}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := NewScreen(10, 2)
+			sc.Write([]byte(tt.input + "x"))
+			if got := sc.Pen(); got != tt.want {
+				t.Errorf("Pen() = %+v, want %+v", got, tt.want)
+			}
+			if got := sc.Cell(0, 0); got != (Cell{Rune: 'x', Style: tt.want}) {
+				t.Errorf("Cell(0, 0) = %+v, want x in %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScreenEraseUsesBackground(t *testing.T) {
+	sc := NewScreen(4, 2)
+	sc.Write([]byte("abcd\033[44m\033[2G\033[K"))
+	want := Cell{Style: Style{BG: PaletteColor(4)}}
+	for x := 1; x < 4; x++ {
+		if got := sc.Cell(x, 0); got != want {
+			t.Errorf("Cell(%d, 0) = %+v, want %+v", x, got, want)
+		}
+	}
+	if got := sc.Cell(0, 0); got.Rune != 'a' {
+		t.Errorf("Cell(0, 0) = %+v, want 'a'", got)
+	}
+}
+
+func TestScreenModes(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Modes
+	}{
+		{"none", "", Modes{}},
+		{"cursor hidden", "\033[?25l", Modes{CursorHidden: true}},
+		{"cursor shown again", "\033[?25l\033[?25h", Modes{}},
+		{"app cursor", "\033[?1h", Modes{AppCursor: true}},
+		{"bracketed paste", "\033[?2004h", Modes{BracketedPaste: true}},
+		{"focus", "\033[?1004h", Modes{FocusEvents: true}},
+		{"several at once", "\033[?1;2004h", Modes{AppCursor: true, BracketedPaste: true}},
+		{"mouse tracking", "\033[?1000h\033[?1002h", Modes{MouseTracking: 1002}},
+		{"mouse tracking off", "\033[?1002h\033[?1000l", Modes{}},
+		{"mouse encoding", "\033[?1006h", Modes{MouseEncoding: 1006}},
+		{"alt screen", "\033[?1049h", Modes{AltScreen: true}},
+		{"alt screen 47", "\033[?47h", Modes{AltScreen: true}},
+		{"insert", "\033[4h", Modes{Insert: true}},
+		{"no autowrap", "\033[?7l", Modes{NoAutoWrap: true}},
+		{"origin", "\033[?6h", Modes{Origin: true}},
+		{"ris clears", "\033[?1;25l\033[?1049h\033c", Modes{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := NewScreen(10, 3)
+			sc.Write([]byte(tt.input))
+			if got := sc.Modes(); got != tt.want {
+				t.Errorf("Modes() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScreenAltScreen(t *testing.T) {
+	sc := NewScreen(10, 3)
+	sc.Write([]byte("main\033[?1049h\033[2;2Halt"))
+	if got, want := sc.Text(), "\n alt\n"; got != want {
+		t.Errorf("alt Text() = %q, want %q", got, want)
+	}
+	sc.Write([]byte("\033[?1049l"))
+	if got, want := sc.Text(), "main\n"; got != want {
+		t.Errorf("main Text() = %q, want %q", got, want)
+	}
+	if x, y, _ := sc.Cursor(); x != 4 || y != 0 {
+		t.Errorf("Cursor() = %d,%d, want the saved 4,0", x, y)
+	}
+
+	// The alternate buffer starts blank every time
+	sc.Write([]byte("\033[?1049h"))
+	if got := sc.Text(); got != "" {
+		t.Errorf("re-entered alt Text() = %q, want blank", got)
+	}
+}
+
+func TestScreenReplies(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"cursor position", "ab\r\n\033[6n", "\033[2;1R"},
+		{"status", "\033[5n", "\033[0n"},
+		{"primary da", "\033[c", "\033[?62;22c"},
+		{"secondary da", "\033[>c", "\033[>0;0;0c"},
+		{"unknown", "\033[7n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := NewScreen(10, 3)
+			var got string
+			sc.SetReply(func(b []byte) { got += string(b) })
+			sc.Write([]byte(tt.input))
+			if got != tt.want {
+				t.Errorf("reply = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScreenResize(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		cols, rows int
+		want       string
+		x, y       int
+	}{
+		{"grow", "ab\r\ncd", 20, 5, "ab\ncd\n", 2, 1},
+		{"narrow truncates", "abcdef", 3, 3, "abc\n", 2, 0},
+		{"shrink keeps cursor row", "1\r\n2\r\n3\r\n4", 5, 2, "3\n4\n", 1, 1},
+		{"shrink below content", "1\r\n2\033[H", 5, 2, "1\n2\n", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := NewScreen(10, 4)
+			sc.Write([]byte(tt.input))
+			sc.Resize(tt.cols, tt.rows)
+			if cols, rows := sc.Size(); cols != tt.cols || rows != tt.rows {
+				t.Errorf("Size() = %d,%d, want %d,%d", cols, rows, tt.cols, tt.rows)
+			}
+			if got := sc.Text(); got != tt.want {
+				t.Errorf("Text() = %q, want %q", got, tt.want)
+			}
+			if x, y, _ := sc.Cursor(); x != tt.x || y != tt.y {
+				t.Errorf("Cursor() = %d,%d, want %d,%d", x, y, tt.x, tt.y)
+			}
+			if top, bottom := sc.ScrollRegion(); top != 0 || bottom != tt.rows-1 {
+				t.Errorf("ScrollRegion() = %d,%d, want the whole screen", top, bottom)
+			}
+		})
+	}
+}
+
+func TestScreenGroundAndShifts(t *testing.T) {
+	sc := NewScreen(10, 2)
+	sc.Write([]byte("a\033["))
+	if sc.Ground() {
+		t.Error("Ground() inside a CSI = true")
+	}
+	sc.Write([]byte("m\xe2"))
+	if sc.Ground() {
+		t.Error("Ground() inside a UTF-8 sequence = true")
+	}
+	sc.Write([]byte("\x9c\x93"))
+	if !sc.Ground() {
+		t.Error("Ground() after a complete rune = false")
+	}
+
+	before := sc.Shifts()
+	sc.Write([]byte("\033[2;1Hx"))
+	if sc.Shifts() != before {
+		t.Error("overwriting cells counted as a shift")
+	}
+	sc.Write([]byte("\r\n"))
+	if sc.Shifts() == before {
+		t.Error("scrolling not counted as a shift")
+	}
+}
+
+func TestPaletteRGB(t *testing.T) {
+	tests := []struct {
+		c       Color
+		r, g, b uint8
+		ok      bool
+	}{
+		{ColorDefault, 0, 0, 0, false},
+		{PaletteColor(1), 205, 0, 0, true},
+		{PaletteColor(15), 255, 255, 255, true},
+		{PaletteColor(16), 0, 0, 0, true},
+		{PaletteColor(196), 255, 0, 0, true},
+		{PaletteColor(231), 255, 255, 255, true},
+		{PaletteColor(232), 8, 8, 8, true},
+		{PaletteColor(255), 238, 238, 238, true},
+		{RGBColor(1, 2, 3), 1, 2, 3, true},
+	}
+	for _, tt := range tests {
+		r, g, b, ok := tt.c.RGB()
+		if r != tt.r || g != tt.g || b != tt.b || ok != tt.ok {
+			t.Errorf("%#x.RGB() = %d,%d,%d,%v, want %d,%d,%d,%v", uint32(tt.c), r, g, b, ok, tt.r, tt.g, tt.b, tt.ok)
+		}
+	}
+}
+
+func TestScreenScrollWrapsRing(t *testing.T) {
+	sc := NewScreen(5, 3)
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintf(sc, "%d\r\n", i)
+	}
+	if got, want := sc.Text(), "9\n10\n"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+
+	// Region scrolls, reverse scrolls and resizes after the ring has wrapped
+	sc.Write([]byte("x\033[2;3r\033[3;1H\r\ny\033[r\033[H\033M"))
+	if got, want := sc.Text(), "\n9\nx\n"; got != want {
+		t.Errorf("Text() after region scroll = %q, want %q", got, want)
+	}
+	sc.Resize(5, 2)
+	if got, want := sc.Text(), "\n9\n"; got != want {
+		t.Errorf("Text() after resize = %q, want %q", got, want)
+	}
+}
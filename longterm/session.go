@@ -0,0 +1,1167 @@
+// Package longterm wraps a command in a PTY that reports a fake terminal
+// height, with an optional interactive command mode for adjusting it at
+// runtime.
+package longterm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// Mode represents the current operating mode
+type Mode uint32
+
+const (
+	ModeNormal  Mode = 0 // Normal I/O passthrough
+	ModeCommand Mode = 1 // Command mode (UI active, intercept input)
+)
+
+// SizeMode selects how the reported height is derived
+type SizeMode int
+
+const (
+	SizeAbsolute SizeMode = 0 // Report a fixed height
+	SizeDelta    SizeMode = 1 // Report real height + delta
+)
+
+// Ways the child can receive long-term's stdin
+const (
+	StdinPTY  = "pty"  // Through the PTY, like typed input
+	StdinPipe = "pipe" // As a pipe of its own
+)
+
+// Options configures a Session
+type Options struct {
+	Mode  SizeMode
+	Value int // Height in absolute mode, offset in delta mode
+
+	// Width reports a fixed width and WidthDelta reports real width + delta.
+	// With both zero the real width is passed through.
+	Width      int
+	WidthDelta int
+
+	// Profiles can be switched to at runtime. Profile names the one the
+	// options were built from, if any.
+	Profiles map[string]Profile
+	Profile  string
+
+	// Real starts the session reporting the real size
+	Real bool
+
+	// Rules are evaluated by ApplyRules to pick a size policy per command
+	Rules []Rule
+
+	// Record, when set, receives the PTY output as an asciicast v2 stream
+	Record io.Writer
+
+	// Headless runs the child against an in-memory screen of the reported
+	// size instead of the real terminal. Output is available from Screen.
+	Headless bool
+
+	// Snapshots keeps an in-memory screen of the child's output, as
+	// headless mode does, so Snapshot and the snapshot key can render it.
+	// Without either, output only goes to the real terminal.
+	Snapshots bool
+
+	// SnapshotFormat and SnapshotDir control snapshots taken from command
+	// mode. They default to html and the current directory.
+	SnapshotFormat string
+	SnapshotDir    string
+
+	// Stdin, Stdout and Stderr default to the process streams. When Stdin
+	// is a terminal it is put into raw mode and used to read the real size.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// StdinMode is StdinPTY (the default) or StdinPipe, which gives the
+	// child stdin directly, with the PTY as its output and controlling
+	// terminal. Stdin must not be a terminal then. When Stdin is a file
+	// or pipe rather than a terminal, command mode is off, and in PTY mode
+	// the child is sent end-of-file once Stdin is consumed.
+	StdinMode string
+
+	// DisableCommandMode turns off the command mode overlay entirely
+	DisableCommandMode bool
+
+	// EscapeKey enters command mode when pressed EscapeCount times within
+	// EscapeWindow (see ParseEscapeKey for the format). Pressed once in
+	// command mode it is sent to the child instead. Unset fields default
+	// to Ctrl+\ three times within 500ms.
+	EscapeKey    string
+	EscapeCount  int
+	EscapeWindow time.Duration
+
+	// Bindings map command mode keys to actions, on top of the defaults.
+	// Keys are descriptions such as "C-Up" and actions are strings such
+	// as "height+=100" (see ParseKey and the README).
+	Bindings map[string]string
+
+	// HandleSignals has the session take over the process's signals. It
+	// forwards Signals, suspends and resumes the process on SIGTSTP and
+	// SIGCONT, and tears down then dies on fatal ones. Without it, signals
+	// are left to the program embedding the session.
+	HandleSignals bool
+
+	// Signals are forwarded to the PTY's foreground process group when
+	// HandleSignals is set. Nil selects DefaultSignals; an empty list
+	// forwards nothing.
+	Signals []os.Signal
+
+	// ControlSocket, when set, is the Unix socket path on which to accept
+	// control commands. It is exported to the child as LONG_TERM_SOCKET.
+	ControlSocket string
+}
+
+// ErrNotStarted is returned by methods that need a running child
+var ErrNotStarted = errors.New("session not started")
+
+// ErrNoSnapshots is returned for snapshots of a session that is neither
+// headless nor keeping a screen for snapshots
+var ErrNoSnapshots = errors.New("snapshots are not enabled for this session")
+
+// Session is a command running behind a PTY with a fake reported height
+type Session struct {
+	args []string
+	opts Options
+
+	cmd      *exec.Cmd
+	ptmx     *os.File
+	termFd   int  // -1 when stdin is not a terminal
+	stdinTTY bool // Stdin is a terminal, even if headless mode ignores it
+	oldState *term.State
+
+	// Real size at startup, used as a fallback when stdin is not a terminal
+	realWidth  int
+	realHeight int
+
+	// Height and delta: single source of truth (atomic for lock-free access)
+	currentHeight atomic.Int32
+	currentDelta  atomic.Int32
+	modeState     atomic.Int32 // 0 = absolute, 1 = delta
+	currentMode   atomic.Uint32
+	useRealSize   atomic.Bool // false = use fake/delta size, true = use real size
+
+	// Width mirrors height, except that zero for both means passthrough
+	currentWidth      atomic.Int32
+	currentWidthDelta atomic.Int32
+
+	profile atomic.Value // string: name of the active profile
+
+	// rule, when set, overrides the user's size settings for a command
+	rule atomic.Pointer[sizePolicy]
+
+	foreground atomic.Value // Foreground: job currently in the foreground of the PTY
+
+	ptyBytes  atomic.Uint64 // Bytes through the PTY in either direction
+	resizes   atomic.Int32  // Size changes pushed to the PTY
+	resources resourceMonitor
+
+	started time.Time // When the child started
+	exited  time.Time // When Wait saw it exit
+
+	// Numeric input state, shared between the command handler and renderer
+	mu         sync.Mutex
+	numericBuf NumericBuffer
+	lastError  string
+	lastInfo   string // Result of the last command shown in the overlay
+	dragging   bool   // Left button held on the height slider
+	history    sizeHistory
+	chosen     bool // Size settings changed from command mode
+
+	control    net.Listener
+	rec        *recorder
+	screen     *Screen
+	host       *hostOutput   // Real terminal output, when the overlay is available
+	outputDone chan struct{} // Closed when the PTY output is exhausted
+	reading    atomic.Bool   // The output copier is waiting on the PTY
+
+	escapeKey   []byte
+	bindings    *bindingTable
+	ui          *uiRenderer
+	kbParser    *keyboardParser
+	sigwinch    chan os.Signal
+	signals     chan os.Signal
+	forwarded   map[os.Signal]bool // Signals passed on rather than handled
+	refreshUI   chan bool
+	done        chan struct{}
+	closeOnce   sync.Once
+	restoreOnce sync.Once
+}
+
+// NewSession prepares a session for args without starting it
+func NewSession(args []string, opts Options) *Session {
+	if opts.Stdin == nil {
+		opts.Stdin = os.Stdin
+	}
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+	if opts.EscapeKey == "" {
+		opts.EscapeKey = DefaultEscapeKey
+	}
+	if opts.EscapeCount <= 0 {
+		opts.EscapeCount = DefaultEscapeCount
+	}
+	if opts.EscapeWindow <= 0 {
+		opts.EscapeWindow = DefaultEscapeWindow
+	}
+
+	s := &Session{
+		args:      args,
+		opts:      opts,
+		termFd:    -1,
+		sigwinch:  make(chan os.Signal, 1),
+		signals:   make(chan os.Signal, 4),
+		refreshUI: make(chan bool, 10),
+		done:      make(chan struct{}),
+	}
+	f, isFile := opts.Stdin.(*os.File)
+	s.stdinTTY = isFile && term.IsTerminal(int(f.Fd()))
+	switch {
+	case opts.Headless:
+		// No real terminal is involved, so there is nothing to query or overlay
+		s.opts.DisableCommandMode = true
+	case s.stdinTTY:
+		s.termFd = int(f.Fd())
+	case isFile:
+		// Piped or redirected input holds no keys to watch for the escape
+		// key in, so all of it goes to the child
+		s.opts.DisableCommandMode = true
+	}
+
+	// Get the real terminal size, defaulting to 80x24 if we can't
+	s.realWidth, s.realHeight = 80, 24
+	if s.termFd >= 0 {
+		if w, h, err := term.GetSize(s.termFd); err == nil {
+			s.realWidth, s.realHeight = w, h
+		}
+	}
+
+	s.applyOptions(opts)
+	s.profile.Store(opts.Profile)
+	s.useRealSize.Store(opts.Real)
+	return s
+}
+
+// applyOptions loads the size atomics from opts
+func (s *Session) applyOptions(opts Options) {
+	s.applyMode(opts.Mode, opts.Value)
+	s.currentWidth.Store(int32(opts.Width))
+	s.currentWidthDelta.Store(int32(opts.WidthDelta))
+}
+
+// applyMode loads the height/delta atomics from a mode and value
+func (s *Session) applyMode(mode SizeMode, value int) {
+	s.modeState.Store(int32(mode))
+	if mode == SizeAbsolute {
+		s.currentHeight.Store(int32(value))
+		s.currentDelta.Store(0)
+	} else {
+		s.currentHeight.Store(int32(s.realHeight + value))
+		s.currentDelta.Store(int32(value))
+	}
+}
+
+// termSize returns the real terminal size, or the startup size if unavailable
+func (s *Session) termSize() (int, int) {
+	if s.termFd >= 0 {
+		if w, h, err := term.GetSize(s.termFd); err == nil {
+			return w, h
+		}
+	}
+	return s.realWidth, s.realHeight
+}
+
+// targetHeight computes the height to report given the real height
+func (s *Session) targetHeight(h int) int {
+	if s.useRealSize.Load() {
+		return h
+	}
+	if p := s.rule.Load(); p != nil {
+		if p.opts.Real {
+			return h
+		}
+		if p.opts.Mode == SizeDelta {
+			return max(h+p.opts.Value, 1)
+		}
+		return p.opts.Value
+	}
+	delta := int(s.currentDelta.Load())
+	if delta != 0 {
+		if h+delta < 1 {
+			return 1
+		}
+		return h + delta
+	}
+	return int(s.currentHeight.Load())
+}
+
+// targetWidth computes the width to report given the real width
+func (s *Session) targetWidth(w int) int {
+	if s.useRealSize.Load() {
+		return w
+	}
+	width, delta := int(s.currentWidth.Load()), int(s.currentWidthDelta.Load())
+	if p := s.rule.Load(); p != nil {
+		if p.opts.Real {
+			return w
+		}
+		width, delta = p.opts.Width, p.opts.WidthDelta
+	}
+	if delta != 0 {
+		if w+delta < 1 {
+			return 1
+		}
+		return w + delta
+	}
+	if width > 0 {
+		return width
+	}
+	return w
+}
+
+// resize asks the SIGWINCH goroutine to push the current size to the PTY
+func (s *Session) resize() {
+	select {
+	case s.sigwinch <- syscall.SIGWINCH:
+	default:
+	}
+}
+
+// triggerRefresh requests an immediate UI redraw
+func (s *Session) triggerRefresh() {
+	select {
+	case s.refreshUI <- true:
+	default:
+	}
+}
+
+// SetHeight reports a fixed height, clearing any delta
+func (s *Session) SetHeight(height int) error {
+	if height < 1 || height > 9999 {
+		return fmt.Errorf("height must be 1-9999")
+	}
+	s.adoptRule()
+	s.currentHeight.Store(int32(height))
+	s.currentDelta.Store(0)
+	s.resize()
+	s.triggerRefresh()
+	return nil
+}
+
+// SetDelta reports the real height plus delta
+func (s *Session) SetDelta(delta int) error {
+	if delta < -9999 || delta > 9999 {
+		return fmt.Errorf("delta must be ±1 to ±9999")
+	}
+	s.adoptRule()
+	s.currentDelta.Store(int32(delta))
+	s.resize()
+	s.triggerRefresh()
+	return nil
+}
+
+// SetWidth reports a fixed width, clearing any width delta. A width of 0
+// passes the real width through.
+func (s *Session) SetWidth(width int) error {
+	if width < 0 || width > 9999 {
+		return fmt.Errorf("width must be 0-9999")
+	}
+	s.adoptRule()
+	s.currentWidth.Store(int32(width))
+	s.currentWidthDelta.Store(0)
+	s.resize()
+	s.triggerRefresh()
+	return nil
+}
+
+// SetWidthDelta reports the real width plus delta
+func (s *Session) SetWidthDelta(delta int) error {
+	if delta < -9999 || delta > 9999 {
+		return fmt.Errorf("delta must be ±1 to ±9999")
+	}
+	s.adoptRule()
+	s.currentWidthDelta.Store(int32(delta))
+	s.resize()
+	s.triggerRefresh()
+	return nil
+}
+
+// ToggleReal switches between the fake and real size, returning true
+// when the real height is now in use
+func (s *Session) ToggleReal() bool {
+	current := s.useRealSize.Load()
+	s.useRealSize.Store(!current)
+	s.resize()
+	s.triggerRefresh()
+	return !current
+}
+
+// Reset restores the mode and value the session was created with
+func (s *Session) Reset() {
+	s.clearRule()
+	s.applyOptions(s.opts)
+	s.profile.Store(s.opts.Profile)
+	s.useRealSize.Store(s.opts.Real)
+	s.resize()
+	s.triggerRefresh()
+}
+
+// Start launches the command and begins proxying I/O
+func (s *Session) Start() error {
+	if s.cmd != nil {
+		return errors.New("session already started")
+	}
+
+	key, err := ParseEscapeKey(s.opts.EscapeKey)
+	if err != nil {
+		return err
+	}
+	s.escapeKey = key
+	if s.bindings, err = newBindingTable(s.opts.Bindings); err != nil {
+		return err
+	}
+
+	// Create UI renderer
+	if s.opts.DisableCommandMode {
+		s.ui = &uiRenderer{available: false, boxWidth: 40}
+	} else {
+		s.ui = newUIRenderer()
+		if !s.ui.available {
+			fmt.Fprintf(s.opts.Stderr, "Warning: /dev/tty unavailable, command mode UI disabled\n")
+		}
+		s.ui.escapeName = escapeKeyName(s.opts.EscapeKey)
+		s.ui.help = s.bindings.help(len(s.opts.Profiles) > 0, s.opts.Headless || s.opts.Snapshots, 37)
+	}
+	s.kbParser = newKeyboardParser()
+
+	// Create the command, using shell if needed for aliases
+	if _, err := exec.LookPath(s.args[0]); err != nil {
+		// Command not found in PATH, try through shell for aliases
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		cmdStr := strings.Join(s.args, " ")
+		s.cmd = exec.Command(shell, "-ic", cmdStr)
+	} else {
+		s.cmd = exec.Command(s.args[0], s.args[1:]...)
+	}
+	setPdeathsig(s.cmd)
+
+	switch s.opts.StdinMode {
+	case "", StdinPTY:
+	case StdinPipe:
+		if s.stdinTTY {
+			s.ui.close()
+			return errors.New("stdin mode pipe needs stdin to be a pipe or file, not a terminal")
+		}
+		s.cmd.Stdin = s.opts.Stdin
+		// The PTY is then the child's stdout, and still its controlling terminal
+		if s.cmd.SysProcAttr == nil {
+			s.cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		s.cmd.SysProcAttr.Ctty = 1
+	default:
+		s.ui.close()
+		return fmt.Errorf("unknown stdin mode %q", s.opts.StdinMode)
+	}
+
+	// Listen for control commands from other processes
+	if s.opts.ControlSocket != "" {
+		if err := s.listenControl(); err != nil {
+			s.ui.close()
+			return err
+		}
+		s.cmd.Env = append(os.Environ(), SocketEnv+"="+s.opts.ControlSocket)
+	}
+
+	// The virtual screen understands xterm sequences even when CI has no TERM
+	if s.opts.Headless && (os.Getenv("TERM") == "" || os.Getenv("TERM") == "dumb") {
+		if s.cmd.Env == nil {
+			s.cmd.Env = os.Environ()
+		}
+		s.cmd.Env = append(s.cmd.Env, "TERM=xterm-256color")
+	}
+
+	// Start with PTY using our effective size
+	w, h := s.termSize()
+	rows, cols := s.targetHeight(h), s.targetWidth(w)
+	if s.opts.Headless || s.opts.Snapshots {
+		s.screen = NewScreen(cols, rows) // Before the loops that read it start
+	}
+	if s.opts.Record != nil {
+		s.rec, err = newRecorder(s.opts.Record, cols, rows, strings.Join(s.args, " "))
+		if err != nil {
+			s.ui.close()
+			s.closeControl()
+			return err
+		}
+	}
+	ptmx, err := pty.StartWithSize(s.cmd, &pty.Winsize{
+		Rows: uint16(rows),
+		Cols: uint16(cols),
+	})
+	if err != nil {
+		s.ui.close()
+		s.closeControl()
+		return fmt.Errorf("failed to start pty: %w", err)
+	}
+	s.ptmx = ptmx
+	s.started = time.Now()
+
+	go s.foregroundLoop()
+	go s.refreshLoop()
+	go s.commandLoop()
+
+	// Handle SIGWINCH (window resize)
+	signal.Notify(s.sigwinch, syscall.SIGWINCH)
+	go s.resizeLoop()
+	// Trigger initial resize
+	s.resize()
+
+	// Display startup hint (before entering raw mode)
+	stderrTTY := false
+	if f, ok := s.opts.Stderr.(*os.File); ok {
+		stderrTTY = term.IsTerminal(int(f.Fd()))
+	}
+	if stderrTTY && s.ui.available {
+		press := escapeKeyName(s.opts.EscapeKey)
+		if s.opts.EscapeCount > 1 {
+			press += fmt.Sprintf(" x%d", s.opts.EscapeCount)
+		}
+		fmt.Fprintf(s.opts.Stderr, "%slong-term: Press %s for command mode%s\n", ansiGray, press, ansiReset)
+	}
+	if stderrTTY && s.control != nil {
+		fmt.Fprintf(s.opts.Stderr, "%slong-term: control socket %s%s\n", ansiGray, s.opts.ControlSocket, ansiReset)
+	}
+
+	// Put terminal into raw mode (only if stdin is a terminal)
+	if s.termFd >= 0 {
+		s.oldState, err = term.MakeRaw(s.termFd)
+		if err != nil {
+			// The child is already running: end it and release the PTY
+			s.Close()
+			return fmt.Errorf("failed to set raw mode: %w", err)
+		}
+	}
+
+	// Forward signals, and suspend cleanly, now that there is a terminal
+	// mode to hand back
+	if s.opts.HandleSignals {
+		s.listenSignals()
+	}
+
+	// Model the real terminal so the overlay can restore what it covers,
+	// starting from wherever the cursor is now
+	var stdout io.Writer = s.opts.Stdout
+	if s.ui.available {
+		s.host = newHostOutput(s.opts.Stdout, w, h, s.ui)
+		stdout = s.host
+		if s.termFd >= 0 {
+			row, col, rest, ok := queryCursor(s.termFd, s.ui.tty)
+			if ok {
+				s.host.moveTo(row, col)
+			}
+			if len(rest) > 0 {
+				s.sendInput(rest)
+			}
+		}
+	}
+
+	if s.opts.StdinMode != StdinPipe {
+		go s.stdinLoop()
+	}
+	// pty -> stdout and the virtual screen, if kept (and the recording).
+	// The screen answers device queries itself only in headless mode;
+	// otherwise the real terminal does.
+	out := stdout
+	if s.screen != nil {
+		out = io.MultiWriter(stdout, s.screen)
+	}
+	if s.opts.Headless {
+		replies := make(chan []byte, 16)
+		s.screen.SetReply(func(b []byte) { replies <- b })
+		go func() {
+			defer s.guard()
+			for b := range replies {
+				ptmx.Write(b)
+			}
+		}()
+		out = s.screen
+	}
+	if s.rec != nil {
+		out = io.MultiWriter(out, s.rec)
+	}
+	out = io.MultiWriter(out, countingWriter{&s.ptyBytes})
+	s.outputDone = make(chan struct{})
+	go func() {
+		defer s.guard()
+		io.Copy(out, outputReader{ptmx, &s.reading})
+		close(s.outputDone)
+	}()
+
+	if s.control != nil {
+		// Status reads the child's process and the screen models, so only
+		// answer once they exist
+		go s.serveControl(s.control)
+	}
+	return nil
+}
+
+// Wait blocks until the command exits. It also waits briefly for the
+// remaining output to reach the screen.
+func (s *Session) Wait() error {
+	if s.cmd == nil || s.ptmx == nil {
+		return ErrNotStarted
+	}
+	err := s.cmd.Wait()
+	s.exited = time.Now()
+	s.drain()
+	return err
+}
+
+// Screen returns the virtual screen that mirrors the child's output at the
+// reported size. It is nil before Start, and unless Headless or Snapshots
+// is set.
+func (s *Session) Screen() *Screen {
+	return s.screen
+}
+
+// Modes returns the terminal modes the child has set, tracked from its
+// output by the virtual screen or the model of the real terminal
+func (s *Session) Modes() Modes {
+	switch {
+	case s.screen != nil:
+		return s.screen.Modes()
+	case s.host != nil:
+		return s.host.screen.Modes()
+	}
+	return Modes{}
+}
+
+// Close ends what is left of the child's process group, escalating from
+// SIGHUP to SIGKILL, then releases the PTY once its output has stopped and
+// restores the terminal
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		err = s.shutdown()
+	})
+	return err
+}
+
+// shutdown does the work of Close
+func (s *Session) shutdown() error {
+	var err error
+	close(s.done)
+	signal.Stop(s.sigwinch)
+	signal.Stop(s.signals)
+	s.killGroup()
+	if s.ptmx != nil {
+		err = s.ptmx.Close()
+		if s.outputDone != nil {
+			select {
+			case <-s.outputDone:
+			case <-time.After(drainTimeout):
+			}
+		}
+	}
+	s.restoreTerminal()
+	if s.ui != nil {
+		s.ui.close()
+	}
+	s.closeControl()
+	if s.rec != nil {
+		if recErr := s.rec.close(); recErr != nil && err == nil {
+			err = fmt.Errorf("recording failed: %w", recErr)
+		}
+	}
+	return err
+}
+
+// stdinLoop proxies stdin to the PTY through the magic key detector, and
+// feeds the keyboard parser in command mode. When stdin runs out it sends
+// the child end-of-file.
+func (s *Session) stdinLoop() {
+	defer s.guard()
+	var magicDet, literalDet *magicDetector
+	if s.ui.available {
+		forward := func(p []byte) { s.sendInput(p) }
+		magicDet = newMagicDetector(forward, s.escapeKey, s.opts.EscapeCount, s.opts.EscapeWindow)
+		// In command mode a single press of the escape key is found the
+		// same way, and everything else goes to the keyboard parser
+		parse := func(p []byte) { s.kbParser.Write(p) }
+		literalDet = newMagicDetector(parse, s.escapeKey, 1, s.opts.EscapeWindow)
+	}
+
+	buf := make([]byte, 1024)
+	partial := false // Input so far ends partway through a line
+	var err error
+	for {
+		var n int
+		n, err = s.opts.Stdin.Read(buf)
+		if err != nil {
+			break
+		}
+		p := buf[:n]
+		if n > 0 {
+			partial = p[n-1] != '\n'
+		}
+
+		if magicDet == nil {
+			s.sendInput(p)
+			continue
+		}
+		for len(p) > 0 {
+			if Mode(s.currentMode.Load()) == ModeNormal {
+				rest, triggered := magicDet.feed(p)
+				if !triggered {
+					break
+				}
+				s.enterCommandMode()
+				p = rest
+				continue
+			}
+
+			// In command mode, input is intercepted by keyboard parser
+			rest, triggered := literalDet.feed(p)
+			if !triggered {
+				break
+			}
+			// Like tmux's send-prefix: the escape key reaches the child
+			s.sendInput(s.escapeKey)
+			s.mu.Lock()
+			s.exitCommandMode()
+			s.mu.Unlock()
+			p = rest
+		}
+	}
+	if magicDet != nil {
+		magicDet.flush()
+		literalDet.flush()
+	}
+	if err == io.EOF {
+		s.sendEOF(partial)
+	}
+}
+
+// sendEOF ends the child's input the way a user at a terminal would, by
+// typing the line discipline's VEOF character, Ctrl+D unless the child
+// changed it. After a partial line it takes two: the first only hands
+// that line over.
+func (s *Session) sendEOF(partial bool) {
+	// A VEOF of 0 is _POSIX_VDISABLE, no character at all
+	veof := byte(0x04)
+	if conn, err := s.ptmx.SyscallConn(); err == nil {
+		conn.Control(func(fd uintptr) {
+			if t, err := unix.IoctlGetTermios(int(fd), ioctlGetTermios); err == nil && t.Cc[unix.VEOF] != 0 {
+				veof = t.Cc[unix.VEOF]
+			}
+		})
+	}
+	if partial {
+		s.sendInput([]byte{veof})
+	}
+	s.sendInput([]byte{veof})
+}
+
+// sendInput writes user input to the PTY, counting it
+func (s *Session) sendInput(p []byte) {
+	n, _ := s.ptmx.Write(p)
+	s.ptyBytes.Add(uint64(n))
+}
+
+// resizeLoop pushes the effective size to the PTY on every SIGWINCH
+func (s *Session) resizeLoop() {
+	defer s.guard()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.sigwinch:
+		}
+
+		w, h := s.termSize()
+		rows, cols := s.targetHeight(h), s.targetWidth(w)
+		s.setPTYSize(rows, cols)
+		if s.rec != nil {
+			s.rec.resize(cols, rows)
+		}
+		if s.screen != nil {
+			s.screen.Resize(cols, rows)
+		}
+		if s.host != nil {
+			s.host.resize(w, h)
+		}
+
+		// Refresh UI if in command mode (handles resize)
+		if Mode(s.currentMode.Load()) == ModeCommand {
+			s.triggerRefresh()
+		}
+	}
+}
+
+// setPTYSize reports rows and cols to the child, counting the change if
+// there was one. The ioctls go through the PTY's raw connection, which
+// Close waits for, and which leaves the master non-blocking as Fd wouldn't.
+func (s *Session) setPTYSize(rows, cols int) {
+	conn, err := s.ptmx.SyscallConn()
+	if err != nil {
+		return
+	}
+	conn.Control(func(fd uintptr) {
+		if old, err := unix.IoctlGetWinsize(int(fd), unix.TIOCGWINSZ); err == nil && (int(old.Row) != rows || int(old.Col) != cols) {
+			s.resizes.Add(1)
+		}
+		unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, &unix.Winsize{Row: uint16(rows), Col: uint16(cols)})
+	})
+}
+
+// enterCommandMode switches to command mode and shows the overlay
+func (s *Session) enterCommandMode() {
+	// Until the overlay's SGR mouse mode takes effect, reports arrive in
+	// whatever encoding the child asked for. If the child had tracking
+	// off, the overlay turns it on.
+	modes := s.Modes()
+	if modes.MouseTracking == 0 {
+		modes.MouseTracking = 1002
+	}
+	s.kbParser.reset()
+	s.kbParser.setModes(modes)
+	s.currentMode.Store(uint32(ModeCommand))
+	s.triggerRefresh()
+}
+
+// refreshLoop redraws the overlay while command mode is active
+func (s *Session) refreshLoop() {
+	defer s.guard()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case <-s.refreshUI:
+		}
+		if Mode(s.currentMode.Load()) == ModeCommand {
+			s.render()
+		}
+	}
+}
+
+func (s *Session) render() {
+	if s.host == nil {
+		return
+	}
+	s.mu.Lock()
+	numBuf, lastError, lastInfo := s.numericBuf, s.lastError, s.lastInfo
+	recent := s.recentLine()
+	s.mu.Unlock()
+	st := s.Status()
+	s.host.paint(func() {
+		// Command mode may have ended while this paint was waiting
+		if Mode(s.currentMode.Load()) == ModeCommand {
+			s.ui.renderBox(st, numBuf, lastError, lastInfo, recent)
+		}
+	})
+}
+
+// recentLine lists the sizes the recall keys bring back. Callers hold
+// s.mu.
+func (s *Session) recentLine() string {
+	var items []string
+	for i, st := range s.history.recent(9) {
+		if key := s.bindings.keyFor(action{kind: actRecall, n: i + 1}); key != "" {
+			items = append(items, key+": "+st.label())
+		}
+	}
+	if len(items) == 0 {
+		return ""
+	}
+	return "Recall " + strings.Join(items, "  ")
+}
+
+// exitCommandMode returns to passthrough and clears the overlay
+func (s *Session) exitCommandMode() {
+	s.currentMode.Store(uint32(ModeNormal))
+	s.numericBuf.reset()
+	s.dragging = false
+	if s.host != nil {
+		s.host.paint(s.ui.clearBox)
+	}
+}
+
+// commandLoop processes keyboard events in command mode
+func (s *Session) commandLoop() {
+	defer s.guard()
+	for {
+		var event KeyEvent
+		select {
+		case <-s.done:
+			return
+		case event = <-s.kbParser.eventChan:
+		}
+		if Mode(s.currentMode.Load()) != ModeCommand {
+			continue
+		}
+		s.mu.Lock()
+		// Record changes made outside command mode too, so undo returns
+		// to them. A slider drag is recorded once, on release.
+		if !s.dragging {
+			s.history.record(s.sizeState())
+		}
+		s.handleKey(event)
+		if !s.dragging {
+			s.history.record(s.sizeState())
+		}
+		s.mu.Unlock()
+	}
+}
+
+// handleKey applies a single command mode key event. Callers hold s.mu.
+func (s *Session) handleKey(event KeyEvent) {
+	// Note changes made here, as opposed to ones from ctl, rules or flags
+	before := s.sizeState()
+	defer func() {
+		if s.sizeState() != before {
+			s.chosen = true
+		}
+	}()
+
+	if event.Code == KeyMouse {
+		s.handleMouse(event.Mouse)
+		return
+	}
+
+	s.lastError = "" // Clear messages on new input
+	s.lastInfo = ""
+
+	// Handle numeric input mode
+	if s.numericBuf.mode != NumericNone {
+		switch event.Code {
+		case KeyESC:
+			s.numericBuf.reset()
+			s.triggerRefresh()
+		case KeyBackspace:
+			s.numericBuf.backspace()
+			s.triggerRefresh()
+		case KeyEnter:
+			val, err := s.numericBuf.value()
+			if err != nil {
+				s.lastError = err.Error()
+				s.triggerRefresh()
+				return
+			}
+			// Apply value
+			switch s.numericBuf.mode {
+			case NumericHeight:
+				s.SetHeight(val)
+			case NumericDelta:
+				s.SetDelta(val)
+			case NumericWidth:
+				s.SetWidth(val)
+			}
+			// Exit command mode after applying value
+			s.exitCommandMode()
+		case KeyChar:
+			if !event.Ctrl && !event.Alt && !event.Meta {
+				s.appendNumeric(event.Char)
+			}
+			s.triggerRefresh()
+		case KeyPaste:
+			for _, r := range event.Text {
+				s.appendNumeric(r)
+			}
+			s.triggerRefresh()
+		}
+		return
+	}
+
+	// Normal command mode handling
+	if a, ok := s.bindings.lookup(event); ok {
+		s.runAction(a)
+	}
+}
+
+// runAction performs the action bound to a key. Callers hold s.mu.
+func (s *Session) runAction(a action) {
+	switch a.kind {
+	case actHeightBy:
+		s.adoptRule()
+		if s.currentDelta.Load() != 0 {
+			s.currentDelta.Add(int32(a.n))
+		} else {
+			newHeight := int(s.currentHeight.Load()) + a.n
+			if newHeight < 1 {
+				newHeight = 1
+			} else if newHeight > 9999 {
+				newHeight = 9999
+			}
+			s.currentHeight.Store(int32(newHeight))
+		}
+		s.resize()
+		s.triggerRefresh()
+
+	case actWidthBy:
+		// Start from the reported width when passing through
+		s.adoptRule()
+		if s.currentWidthDelta.Load() != 0 {
+			s.currentWidthDelta.Add(int32(a.n))
+		} else {
+			w, _ := s.termSize()
+			newWidth := s.targetWidth(w) + a.n
+			if newWidth < 1 {
+				newWidth = 1
+			} else if newWidth > 9999 {
+				newWidth = 9999
+			}
+			s.currentWidth.Store(int32(newWidth))
+		}
+		s.resize()
+		s.triggerRefresh()
+
+	case actHeightSet:
+		s.SetHeight(a.n)
+	case actDeltaSet:
+		s.SetDelta(a.n)
+	case actWidthSet:
+		s.SetWidth(a.n)
+
+	case actPromptHeight:
+		s.numericBuf.mode = NumericHeight
+		s.numericBuf.digits = nil
+		s.triggerRefresh()
+	case actPromptDelta:
+		s.numericBuf.mode = NumericDelta
+		s.numericBuf.digits = nil
+		s.triggerRefresh()
+	case actPromptWidth:
+		s.numericBuf.mode = NumericWidth
+		s.numericBuf.digits = nil
+		s.triggerRefresh()
+
+	case actProfile:
+		if err := s.SetProfile(a.name); err != nil {
+			s.lastError = err.Error()
+			s.triggerRefresh()
+		}
+	case actNextProfile:
+		// Cycle through configured profiles
+		if _, err := s.NextProfile(); err != nil {
+			s.lastError = err.Error()
+			s.triggerRefresh()
+		}
+
+	case actSnapshot:
+		// Save the child's screen as it looks at the reported size
+		if name, err := s.saveSnapshot(); err != nil {
+			s.lastError = err.Error()
+		} else {
+			s.lastInfo = "Saved " + name
+		}
+		s.triggerRefresh()
+
+	case actUndo:
+		if st, ok := s.history.undo(); ok {
+			s.applySize(st)
+		} else {
+			s.lastInfo = "Nothing to undo"
+			s.triggerRefresh()
+		}
+	case actRedo:
+		if st, ok := s.history.redo(); ok {
+			s.applySize(st)
+		} else {
+			s.lastInfo = "Nothing to redo"
+			s.triggerRefresh()
+		}
+	case actRecall:
+		if recent := s.history.recent(a.n); len(recent) == a.n {
+			s.applySize(recent[a.n-1])
+		}
+
+	case actToggle:
+		// Toggle real/fake size
+		s.ToggleReal()
+	case actReset:
+		// Reset to defaults from flags
+		s.Reset()
+	case actSuspend:
+		// Handled like a SIGTSTP from outside, once s.mu is released.
+		// Without signal handling nothing would put the terminal back.
+		if !s.opts.HandleSignals {
+			s.lastError = "Suspend needs signal handling"
+			s.triggerRefresh()
+			return
+		}
+		s.exitCommandMode()
+		unix.Kill(os.Getpid(), unix.SIGTSTP)
+	case actExit:
+		s.exitCommandMode()
+	}
+}
+
+// handleMouse applies a click, drag or wheel on the overlay's controls.
+// Callers hold s.mu.
+func (s *Session) handleMouse(m MouseEvent) {
+	if s.host == nil {
+		return
+	}
+	control, pos := s.host.control(m.X, m.Y)
+	switch {
+	case m.Release:
+		s.dragging = false
+	case m.Motion:
+		if s.dragging {
+			s.SetHeight(sliderHeight(pos))
+		}
+	case control == controlNone:
+	case m.Button == MouseWheelUp:
+		s.runAction(action{kind: actHeightBy, n: 1})
+	case m.Button == MouseWheelDown:
+		s.runAction(action{kind: actHeightBy, n: -1})
+	case m.Button != MouseLeft:
+	case control == controlMinus:
+		s.runAction(action{kind: actHeightBy, n: -1})
+	case control == controlPlus:
+		s.runAction(action{kind: actHeightBy, n: 1})
+	case control == controlSlider:
+		s.dragging = true
+		s.SetHeight(sliderHeight(pos))
+	case control == controlToggle:
+		s.ToggleReal()
+	}
+}
+
+// appendNumeric adds a typed or pasted character to the numeric entry,
+// ignoring anything that can't be part of the value. Callers hold s.mu.
+func (s *Session) appendNumeric(r rune) {
+	if r >= '0' && r <= '9' {
+		s.numericBuf.append(r)
+	} else if s.numericBuf.mode == NumericDelta && len(s.numericBuf.digits) == 0 {
+		if r == '+' || r == '-' {
+			s.numericBuf.append(r)
+		}
+	}
+}
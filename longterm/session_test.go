@@ -0,0 +1,43 @@
+package longterm
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSessionEOFAfterPartialLine pipes input without a final newline into
+// cat, which only ends if it is handed the partial line and then EOF
+func TestSessionEOFAfterPartialLine(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	s := NewSession([]string{"sh", "-c", "cat > " + out}, Options{
+		Stdin:              strings.NewReader("abc"),
+		Stdout:             io.Discard,
+		Stderr:             io.Discard,
+		DisableCommandMode: true,
+	})
+	defer s.Close()
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not end after stdin ran out")
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "abc" {
+		t.Errorf("cat read %q, want %q", data, "abc")
+	}
+}
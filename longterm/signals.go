@@ -0,0 +1,151 @@
+package longterm
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// DefaultSignals are forwarded to the wrapped program when
+// Options.Signals is nil. SIGINT is added when stdin isn't a terminal;
+// otherwise Ctrl+C already reaches the program as a key.
+var DefaultSignals = []os.Signal{unix.SIGTERM, unix.SIGHUP, unix.SIGUSR1, unix.SIGUSR2}
+
+// ParseSignals parses a comma-separated list of signal names or numbers,
+// such as "TERM,HUP" or "SIGUSR1,12". "none" yields an empty list. A
+// signal named more than once is only listed once.
+func ParseSignals(list string) ([]os.Signal, error) {
+	sigs := []os.Signal{}
+	if list == "none" {
+		return sigs, nil
+	}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		var sig syscall.Signal
+		if n, err := strconv.Atoi(name); err == nil {
+			sig = syscall.Signal(n)
+			if unix.SignalName(sig) == "" {
+				return nil, fmt.Errorf("unknown signal %d", n)
+			}
+		} else {
+			if !strings.HasPrefix(name, "SIG") {
+				name = "SIG" + name
+			}
+			if sig = unix.SignalNum(name); sig == 0 {
+				return nil, fmt.Errorf("unknown signal %q", name)
+			}
+		}
+		switch sig {
+		case unix.SIGKILL, unix.SIGSTOP:
+			return nil, fmt.Errorf("%s can't be caught, so it can't be forwarded", unix.SignalName(sig))
+		case unix.SIGTSTP, unix.SIGCONT, unix.SIGWINCH:
+			return nil, fmt.Errorf("%s is handled by long-term itself", unix.SignalName(sig))
+		}
+		if !slices.Contains(sigs, os.Signal(sig)) {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs, nil
+}
+
+// listenSignals subscribes to the signals to forward, to the job control
+// signals long-term handles itself, and to the fatal ones it needs to
+// clean up after. Signals ignored when long-term started are left that
+// way: catching one would undo the ignore, and re-raising a fatal one
+// would then do nothing.
+func (s *Session) listenSignals() {
+	sigs := s.opts.Signals
+	if sigs == nil {
+		sigs = DefaultSignals
+		if s.termFd < 0 {
+			sigs = append(sigs[:len(sigs):len(sigs)], unix.SIGINT)
+		}
+	}
+	s.forwarded = make(map[os.Signal]bool)
+	caught := []os.Signal{unix.SIGTSTP, unix.SIGCONT}
+	for _, sig := range sigs {
+		if !signal.Ignored(sig) {
+			s.forwarded[sig] = true
+			caught = append(caught, sig)
+		}
+	}
+	for _, sig := range fatalSignals {
+		if !signal.Ignored(sig) && !s.forwarded[sig] {
+			caught = append(caught, sig)
+		}
+	}
+	signal.Notify(s.signals, caught...)
+	go s.signalLoop()
+}
+
+// signalLoop forwards signals to the PTY's foreground process group, and
+// suspends and resumes long-term on job control signals
+func (s *Session) signalLoop() {
+	defer s.guard()
+	for {
+		var sig os.Signal
+		select {
+		case <-s.done:
+			return
+		case sig = <-s.signals:
+		}
+		switch sig {
+		case unix.SIGTSTP:
+			s.suspend()
+		case unix.SIGCONT:
+			s.resume()
+		default:
+			if !s.forwarded[sig] {
+				s.die(sig.(syscall.Signal))
+				return
+			}
+			s.signalForeground(sig.(syscall.Signal))
+		}
+	}
+}
+
+// signalForeground sends sig to the PTY's foreground process group,
+// falling back to the child's own group, which it leads
+func (s *Session) signalForeground(sig syscall.Signal) {
+	pgid, err := s.foregroundPGID()
+	if err != nil || pgid <= 0 {
+		pgid = s.cmd.Process.Pid
+	}
+	unix.Kill(-pgid, sig)
+}
+
+// suspend stops long-term, handing the terminal back in the mode it was
+// found. The child isn't stopped: its process group is orphaned, so the
+// kernel would drop SIGTSTP, and a shell inside would take the stop as its
+// cue to take over the PTY. It blocks once the PTY's buffer fills instead.
+func (s *Session) suspend() {
+	s.mu.Lock()
+	if Mode(s.currentMode.Load()) == ModeCommand {
+		s.exitCommandMode()
+	}
+	s.mu.Unlock()
+
+	if s.oldState != nil {
+		term.Restore(s.termFd, s.oldState)
+	}
+	// SIGTSTP itself is caught, so stop with the uncatchable SIGSTOP. The
+	// shell sees the job stop as usual, and SIGCONT brings us to resume.
+	unix.Kill(os.Getpid(), unix.SIGSTOP)
+}
+
+// resume re-enters raw mode after a stop and sends the foreground job the
+// fake size again so it redraws over whatever the shell printed
+func (s *Session) resume() {
+	if s.oldState != nil {
+		term.MakeRaw(s.termFd)
+	}
+	s.resize()
+	s.signalForeground(unix.SIGWINCH)
+}
@@ -0,0 +1,49 @@
+package longterm
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseSignals(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []os.Signal
+		wantErr bool
+	}{
+		{"TERM", []os.Signal{unix.SIGTERM}, false},
+		{"SIGTERM", []os.Signal{unix.SIGTERM}, false},
+		{"term,sighup", []os.Signal{unix.SIGTERM, unix.SIGHUP}, false},
+		{" USR1 , USR2 ", []os.Signal{unix.SIGUSR1, unix.SIGUSR2}, false},
+		{"15", []os.Signal{unix.SIGTERM}, false},
+		{"HUP,10", []os.Signal{unix.SIGHUP, unix.Signal(10)}, false},
+		{"none", []os.Signal{}, false},
+		{"HUP,SIGHUP,1", []os.Signal{unix.SIGHUP}, false},
+		{"INT,TERM,INT", []os.Signal{unix.SIGINT, unix.SIGTERM}, false},
+		{"BOGUS", nil, true},
+		{"SIGBOGUS", nil, true},
+		{"TERM,BOGUS", nil, true},
+		{"999", nil, true},
+		{"0", nil, true},
+		{"", nil, true},
+		{"TERM,", nil, true},
+		{"KILL", nil, true},
+		{"STOP", nil, true},
+		{"TSTP", nil, true},
+		{"CONT", nil, true},
+		{"WINCH", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSignals(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSignals(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("ParseSignals(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
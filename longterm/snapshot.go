@@ -0,0 +1,308 @@
+package longterm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Snapshot formats
+const (
+	FormatHTML = "html"
+	FormatSVG  = "svg"
+	FormatANSI = "ansi"
+)
+
+// Default colors for cells that use the terminal's default colors
+const (
+	snapshotFG = "#d0d0d0"
+	snapshotBG = "#1e1e1e"
+)
+
+// SVG cell geometry in pixels
+const (
+	svgFontSize   = 14
+	svgCellWidth  = 8.4
+	svgCellHeight = 17
+)
+
+// snapshotRun is a run of cells sharing a style
+type snapshotRun struct {
+	x     int
+	text  string
+	style Style
+}
+
+// WriteSnapshot renders the screen's active buffer in the given format,
+// keeping colors, attributes and the cursor. Blank rows below both the
+// last content and the cursor are omitted.
+func WriteSnapshot(w io.Writer, sc *Screen, format string) error {
+	bw := bufio.NewWriter(w)
+	rows, cols := snapshotRows(sc)
+	switch format {
+	case FormatHTML:
+		writeHTML(bw, rows)
+	case FormatSVG:
+		writeSVG(bw, rows, cols)
+	case FormatANSI:
+		writeANSI(bw, rows)
+	default:
+		return fmt.Errorf("unknown snapshot format %q (want html, svg or ansi)", format)
+	}
+	return bw.Flush()
+}
+
+// Snapshot renders the child's current screen at the reported size. The
+// session must be headless or keeping a screen for snapshots.
+func (s *Session) Snapshot(w io.Writer, format string) error {
+	switch {
+	case s.cmd == nil:
+		return ErrNotStarted
+	case s.screen == nil:
+		return ErrNoSnapshots
+	}
+	return WriteSnapshot(w, s.screen, format)
+}
+
+// saveSnapshot writes a timestamped snapshot to the snapshot directory and
+// returns its file name
+func (s *Session) saveSnapshot() (string, error) {
+	if s.screen == nil {
+		return "", ErrNoSnapshots
+	}
+	format := s.opts.SnapshotFormat
+	if format == "" {
+		format = FormatHTML
+	}
+	dir := s.opts.SnapshotDir
+	if dir == "" {
+		dir = "."
+	}
+
+	// Never overwrite an earlier snapshot, even one from the same
+	// millisecond: number the name until it is free
+	stamp := time.Now().Format("20060102-150405.000")
+	name := fmt.Sprintf("long-term-%s.%s", stamp, format)
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	for n := 2; errors.Is(err, fs.ErrExist); n++ {
+		name = fmt.Sprintf("long-term-%s-%d.%s", stamp, n, format)
+		f, err = os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	}
+	if err != nil {
+		return "", fmt.Errorf("snapshot: %w", err)
+	}
+	if err := s.Snapshot(f, format); err != nil {
+		f.Close()
+		return "", err
+	}
+	return name, f.Close()
+}
+
+// snapshotRows splits the screen into styled runs per row, marking the
+// cursor cell with reverse video when it is visible
+func snapshotRows(sc *Screen) (rows [][]snapshotRun, cols int) {
+	lines := sc.Lines()
+	cols, _ = sc.Size()
+	cx, cy, cursor := sc.Cursor()
+
+	// Keep rows up to the last non-blank one or the cursor
+	last := -1
+	if cursor {
+		last = cy
+	}
+	for y, line := range lines {
+		for _, c := range line {
+			if c.Rune != 0 && c.Rune != ' ' || c.Style.BG != ColorDefault {
+				last = max(last, y)
+				break
+			}
+		}
+	}
+
+	for y := 0; y <= last; y++ {
+		line := lines[y]
+		if line == nil {
+			line = make([]Cell, cols)
+		}
+		if cursor && y == cy {
+			line[cx].Style.Attrs ^= AttrReverse
+		}
+
+		// Drop trailing default blanks
+		end := len(line)
+		for end > 0 && line[end-1].Rune == 0 && line[end-1].Style == (Style{}) {
+			end--
+		}
+
+		var runs []snapshotRun
+		for x := 0; x < end; {
+			start, style := x, line[x].Style
+			var b strings.Builder
+			for ; x < end && line[x].Style == style; x++ {
+				if r := line[x].Rune; r == 0 {
+					b.WriteByte(' ')
+				} else {
+					b.WriteRune(r)
+				}
+			}
+			runs = append(runs, snapshotRun{x: start, text: b.String(), style: style})
+		}
+		rows = append(rows, runs)
+	}
+	return rows, cols
+}
+
+// cssColors resolves a style to foreground and background CSS colors
+func cssColors(st Style) (fg, bg string) {
+	fg, bg = snapshotFG, snapshotBG
+	if r, g, b, ok := st.FG.RGB(); ok {
+		fg = fmt.Sprintf("#%02x%02x%02x", r, g, b)
+	}
+	if r, g, b, ok := st.BG.RGB(); ok {
+		bg = fmt.Sprintf("#%02x%02x%02x", r, g, b)
+	}
+	if st.Attrs&AttrReverse != 0 {
+		fg, bg = bg, fg
+	}
+	if st.Attrs&AttrHidden != 0 {
+		fg = bg
+	}
+	return fg, bg
+}
+
+// cssDecorations returns font and decoration properties for a style
+func cssDecorations(st Style) []string {
+	var props []string
+	if st.Attrs&AttrBold != 0 {
+		props = append(props, "font-weight:bold")
+	}
+	if st.Attrs&AttrItalic != 0 {
+		props = append(props, "font-style:italic")
+	}
+	if st.Attrs&AttrDim != 0 {
+		props = append(props, "opacity:0.6")
+	}
+	var deco []string
+	if st.Attrs&AttrUnderline != 0 {
+		deco = append(deco, "underline")
+	}
+	if st.Attrs&AttrStrike != 0 {
+		deco = append(deco, "line-through")
+	}
+	if len(deco) > 0 {
+		props = append(props, "text-decoration:"+strings.Join(deco, " "))
+	}
+	return props
+}
+
+func writeHTML(w *bufio.Writer, rows [][]snapshotRun) {
+	fmt.Fprintf(w, "<pre style=\"font-family:monospace;line-height:1.2;color:%s;background:%s;padding:0.5em\">", snapshotFG, snapshotBG)
+	for i, runs := range rows {
+		if i > 0 {
+			w.WriteByte('\n')
+		}
+		for _, run := range runs {
+			text := html.EscapeString(run.text)
+			if run.style == (Style{}) {
+				w.WriteString(text)
+				continue
+			}
+			fg, bg := cssColors(run.style)
+			props := []string{"color:" + fg}
+			if bg != snapshotBG {
+				props = append(props, "background:"+bg)
+			}
+			props = append(props, cssDecorations(run.style)...)
+			fmt.Fprintf(w, "<span style=\"%s\">%s</span>", strings.Join(props, ";"), text)
+		}
+	}
+	w.WriteString("</pre>\n")
+}
+
+func writeSVG(w *bufio.Writer, rows [][]snapshotRun, cols int) {
+	width := float64(cols) * svgCellWidth
+	height := float64(max(len(rows), 1)) * svgCellHeight
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%.1f\" height=\"%d\" viewBox=\"0 0 %.1f %d\">\n",
+		width, int(height), width, int(height))
+	fmt.Fprintf(w, "<rect width=\"100%%\" height=\"100%%\" fill=\"%s\"/>\n", snapshotBG)
+	fmt.Fprintf(w, "<g font-family=\"monospace\" font-size=\"%d\" xml:space=\"preserve\">\n", svgFontSize)
+	for y, runs := range rows {
+		top := float64(y) * svgCellHeight
+		for _, run := range runs {
+			fg, bg := cssColors(run.style)
+			x := float64(run.x) * svgCellWidth
+			n := len([]rune(run.text))
+			if bg != snapshotBG {
+				fmt.Fprintf(w, "<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%d\" fill=\"%s\"/>\n",
+					x, top, float64(n)*svgCellWidth, svgCellHeight, bg)
+			}
+			if strings.TrimSpace(run.text) == "" {
+				continue
+			}
+			style := ""
+			if props := cssDecorations(run.style); len(props) > 0 {
+				style = fmt.Sprintf(" style=\"%s\"", strings.Join(props, ";"))
+			}
+			fmt.Fprintf(w, "<text x=\"%.1f\" y=\"%.1f\" fill=\"%s\" textLength=\"%.1f\"%s>%s</text>\n",
+				x, top+svgCellHeight*0.8, fg, float64(n)*svgCellWidth, style, html.EscapeString(run.text))
+		}
+	}
+	w.WriteString("</g>\n</svg>\n")
+}
+
+func writeANSI(w *bufio.Writer, rows [][]snapshotRun) {
+	for _, runs := range rows {
+		for _, run := range runs {
+			w.WriteString(sgrSequence(run.style))
+			w.WriteString(run.text)
+		}
+		w.WriteString(ansiReset)
+		w.WriteByte('\n')
+	}
+}
+
+// sgrSequence returns the SGR escape that selects a style from scratch
+func sgrSequence(st Style) string {
+	params := []string{"0"}
+	attrs := []struct {
+		attr Attr
+		code string
+	}{
+		{AttrBold, "1"}, {AttrDim, "2"}, {AttrItalic, "3"}, {AttrUnderline, "4"},
+		{AttrBlink, "5"}, {AttrReverse, "7"}, {AttrHidden, "8"}, {AttrStrike, "9"},
+	}
+	for _, a := range attrs {
+		if st.Attrs&a.attr != 0 {
+			params = append(params, a.code)
+		}
+	}
+	params = append(params, sgrColor(st.FG, 30)...)
+	params = append(params, sgrColor(st.BG, 40)...)
+	return "\033[" + strings.Join(params, ";") + "m"
+}
+
+// sgrColor encodes a color relative to base (30 for foreground, 40 for
+// background)
+func sgrColor(c Color, base int) []string {
+	if n, ok := c.Palette(); ok {
+		switch {
+		case n < 8:
+			return []string{fmt.Sprint(base + int(n))}
+		case n < 16:
+			return []string{fmt.Sprint(base + 60 + int(n) - 8)}
+		default:
+			return []string{fmt.Sprint(base + 8), "5", fmt.Sprint(n)}
+		}
+	}
+	if r, g, b, ok := c.RGB(); ok {
+		return []string{fmt.Sprint(base + 8), "2", fmt.Sprint(r), fmt.Sprint(g), fmt.Sprint(b)}
+	}
+	return nil
+}
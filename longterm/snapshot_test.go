@@ -0,0 +1,39 @@
+package longterm
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestSaveSnapshotKeepsEarlierOnes(t *testing.T) {
+	dir := t.TempDir()
+	s := NewSession([]string{"true"}, Options{
+		Stdin:          strings.NewReader(""),
+		SnapshotFormat: FormatANSI,
+		SnapshotDir:    dir,
+	})
+	s.cmd = exec.Command("true")
+	s.screen = NewScreen(10, 2)
+	s.screen.Write([]byte("hi"))
+
+	names := make(map[string]bool)
+	for range 5 {
+		name, err := s.saveSnapshot()
+		if err != nil {
+			t.Fatalf("saveSnapshot: %v", err)
+		}
+		if names[name] {
+			t.Fatalf("saveSnapshot reused %s", name)
+		}
+		names[name] = true
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(names) {
+		t.Errorf("%d files in the snapshot directory, want %d", len(entries), len(names))
+	}
+}
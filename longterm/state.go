@@ -0,0 +1,158 @@
+package longterm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SavedSize is the size last chosen in command mode for a command
+type SavedSize struct {
+	Height     int       `json:"height,omitempty"` // Used when Delta is 0
+	Delta      int       `json:"delta,omitempty"`
+	Width      int       `json:"width,omitempty"`
+	WidthDelta int       `json:"width_delta,omitempty"`
+	Real       bool      `json:"real,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// Apply returns opts with the saved size layered on top
+func (sz SavedSize) Apply(opts Options) Options {
+	if sz.Delta != 0 {
+		opts.Mode, opts.Value = SizeDelta, sz.Delta
+	} else {
+		opts.Mode, opts.Value = SizeAbsolute, sz.Height
+	}
+	opts.Width, opts.WidthDelta = sz.Width, sz.WidthDelta
+	opts.Real = sz.Real
+	return opts
+}
+
+// String describes the size, e.g. "height 500 width 80" or "delta +20"
+func (sz SavedSize) String() string {
+	var parts []string
+	switch {
+	case sz.Real:
+		parts = append(parts, "real")
+	case sz.Delta != 0:
+		parts = append(parts, "delta "+signed(sz.Delta))
+	default:
+		parts = append(parts, "height "+strconv.Itoa(sz.Height))
+	}
+	switch {
+	case sz.Real:
+	case sz.WidthDelta != 0:
+		parts = append(parts, "width-delta "+signed(sz.WidthDelta))
+	case sz.Width != 0:
+		parts = append(parts, "width "+strconv.Itoa(sz.Width))
+	}
+	return strings.Join(parts, " ")
+}
+
+// State is the contents of the state file: sizes remembered per command
+type State struct {
+	Sizes map[string]SavedSize `json:"sizes"`
+}
+
+// StatePath returns sizes.json under $XDG_STATE_HOME/long-term (default
+// ~/.local/state/long-term)
+func StatePath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "long-term", "sizes.json")
+}
+
+// StateKey names the entry for a command: the program name, or with full
+// set, the whole command line
+func StateKey(argv []string, full bool) string {
+	if full {
+		return strings.Join(argv, " ")
+	}
+	return filepath.Base(argv[0])
+}
+
+// LoadState reads the state file at path. A missing file yields an empty
+// state rather than an error.
+func LoadState(path string) (*State, error) {
+	st := &State{Sizes: make(map[string]SavedSize)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to load state %s: %w", path, err)
+	}
+	if st.Sizes == nil {
+		st.Sizes = make(map[string]SavedSize)
+	}
+	return st, nil
+}
+
+// Save writes the state to path, replacing the file atomically
+func (st *State) Save(path string) error {
+	if path == "" {
+		return fmt.Errorf("no state directory")
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
+
+// Keys returns the remembered commands in sorted order
+func (st *State) Keys() []string {
+	keys := make([]string, 0, len(st.Sizes))
+	for key := range st.Sizes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ChosenSize returns the size settings as they stand, if any were changed
+// in command mode
+func (s *Session) ChosenSize() (SavedSize, bool) {
+	s.mu.Lock()
+	changed := s.chosen
+	s.mu.Unlock()
+	if !changed {
+		return SavedSize{}, false
+	}
+	st := s.sizeState()
+	return SavedSize{
+		Height:     st.height,
+		Delta:      st.delta,
+		Width:      st.width,
+		WidthDelta: st.widthDelta,
+		Real:       st.real,
+		Updated:    time.Now(),
+	}, true
+}
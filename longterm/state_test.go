@@ -0,0 +1,125 @@
+package longterm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "long-term", "sizes.json")
+	updated := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	st := &State{Sizes: map[string]SavedSize{
+		"less":         {Height: 500, Width: 120, Updated: updated},
+		"git log":      {Delta: -20, WidthDelta: 10, Updated: updated},
+		"vim":          {Real: true, Updated: updated},
+		"/usr/bin/top": {Height: 40, Updated: updated},
+	}}
+	if err := st.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	got, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if len(got.Sizes) != len(st.Sizes) {
+		t.Fatalf("loaded %d sizes, want %d", len(got.Sizes), len(st.Sizes))
+	}
+	for key, want := range st.Sizes {
+		if sz := got.Sizes[key]; sz != want {
+			t.Errorf("Sizes[%q] = %+v, want %+v", key, sz, want)
+		}
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestLoadStateMissing(t *testing.T) {
+	st, err := LoadState(filepath.Join(t.TempDir(), "sizes.json"))
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if st.Sizes == nil || len(st.Sizes) != 0 {
+		t.Errorf("Sizes = %v, want empty", st.Sizes)
+	}
+}
+
+func TestLoadStateCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"garbage":   "not json",
+		"truncated": `{"sizes": {"less": {"height": 5`,
+		"wrong":     `{"sizes": []}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadState(path); err == nil {
+			t.Errorf("LoadState(%s): expected an error", name)
+		}
+	}
+
+	// A file without sizes still gives a map to add to
+	path := filepath.Join(dir, "empty")
+	if err := os.WriteFile(path, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	st, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("LoadState(empty): %v", err)
+	}
+	if st.Sizes == nil {
+		t.Error("Sizes is nil")
+	}
+}
+
+func TestStateSaveNoPath(t *testing.T) {
+	st := &State{Sizes: map[string]SavedSize{}}
+	if err := st.Save(""); err == nil {
+		t.Error("Save(\"\"): expected an error")
+	}
+}
+
+func TestStateKey(t *testing.T) {
+	tests := []struct {
+		argv []string
+		full bool
+		want string
+	}{
+		{[]string{"less"}, false, "less"},
+		{[]string{"/usr/bin/git", "log", "--oneline"}, false, "git"},
+		{[]string{"./bin/tool", "-x"}, false, "tool"},
+		{[]string{"less"}, true, "less"},
+		{[]string{"/usr/bin/git", "log", "--oneline"}, true, "/usr/bin/git log --oneline"},
+	}
+	for _, tt := range tests {
+		if got := StateKey(tt.argv, tt.full); got != tt.want {
+			t.Errorf("StateKey(%q, %v) = %q, want %q", tt.argv, tt.full, got, tt.want)
+		}
+	}
+}
+
+func TestSavedSizeApply(t *testing.T) {
+	base := Options{Mode: SizeDelta, Value: 2000, Width: 100, Real: true}
+	tests := []struct {
+		sz   SavedSize
+		want Options
+	}{
+		{SavedSize{Height: 500}, Options{Mode: SizeAbsolute, Value: 500}},
+		{SavedSize{Delta: -20, WidthDelta: 5}, Options{Mode: SizeDelta, Value: -20, WidthDelta: 5}},
+		{SavedSize{Height: 40, Width: 80}, Options{Mode: SizeAbsolute, Value: 40, Width: 80}},
+		{SavedSize{Real: true}, Options{Mode: SizeAbsolute, Real: true}},
+	}
+	for _, tt := range tests {
+		got := tt.sz.Apply(base)
+		if got.Mode != tt.want.Mode || got.Value != tt.want.Value || got.Width != tt.want.Width ||
+			got.WidthDelta != tt.want.WidthDelta || got.Real != tt.want.Real {
+			t.Errorf("%+v.Apply = mode %v value %d width %d/%+d real %v, want mode %v value %d width %d/%+d real %v",
+				tt.sz, got.Mode, got.Value, got.Width, got.WidthDelta, got.Real,
+				tt.want.Mode, tt.want.Value, tt.want.Width, tt.want.WidthDelta, tt.want.Real)
+		}
+	}
+}
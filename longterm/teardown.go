@@ -0,0 +1,154 @@
+package longterm
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// Limits on an orderly shutdown
+const (
+	drainTimeout = 2 * time.Second        // For the last output once the child exits
+	drainPoll    = 10 * time.Millisecond  // How often the PTY is checked for more
+	killGrace    = 500 * time.Millisecond // Between SIGHUP, SIGTERM and SIGKILL
+	dieGrace     = time.Second            // For a re-raised fatal signal to land
+)
+
+// fatalSignals end long-term when they aren't being forwarded. They are
+// caught so the terminal can be put back first, unless they were ignored
+// when long-term started, as under nohup.
+var fatalSignals = []os.Signal{unix.SIGINT, unix.SIGQUIT, unix.SIGTERM, unix.SIGHUP}
+
+// guard puts the terminal back if the goroutine it is deferred in panics.
+// The panic then carries on, and its trace still shows where it started.
+func (s *Session) guard() {
+	if r := recover(); r != nil {
+		s.restoreTerminal()
+		panic(r)
+	}
+}
+
+// die tears the session down after a fatal signal, then lets the signal
+// take its default effect so that the exit status reports it. Both happen
+// under closeOnce, so a Close racing with this can't return first and let
+// long-term exit some other way.
+func (s *Session) die(sig syscall.Signal) {
+	s.closeOnce.Do(func() {
+		s.shutdown()
+		signal.Reset(sig)
+		unix.Kill(os.Getpid(), sig)
+		// The signal may land after Kill returns. Until it does, hold
+		// any Close waiting on closeOnce, so nothing exits first. Should
+		// it not land at all, exit with the status it would have given.
+		time.Sleep(dieGrace)
+		os.Exit(128 + int(sig))
+	})
+}
+
+// restoreTerminal clears the overlay, shows the cursor and leaves raw
+// mode. Only the first call does anything.
+func (s *Session) restoreTerminal() {
+	s.restoreOnce.Do(func() {
+		if s.host != nil {
+			s.host.teardown()
+		}
+		if s.oldState != nil {
+			term.Restore(s.termFd, s.oldState)
+		}
+	})
+}
+
+// drain waits for the output still in the PTY to reach the terminal and
+// the screen. The PTY only reports the end of output once every process
+// holding it open has exited, so output is also done when the copier is
+// waiting on an empty PTY, twice in a row to let data in flight through.
+func (s *Session) drain() {
+	deadline := time.After(drainTimeout)
+	ticker := time.NewTicker(drainPoll)
+	defer ticker.Stop()
+	idle := 0
+	for idle < 2 {
+		select {
+		case <-s.outputDone:
+			return
+		case <-deadline:
+			return
+		case <-ticker.C:
+		}
+		if s.reading.Load() && s.pendingOutput() == 0 {
+			idle++
+		} else {
+			idle = 0
+		}
+	}
+}
+
+// pendingOutput returns the number of bytes waiting to be read from the
+// PTY, or -1 if it can't tell
+func (s *Session) pendingOutput() int {
+	conn, err := s.ptmx.SyscallConn()
+	if err != nil {
+		return -1
+	}
+	n := -1
+	conn.Control(func(fd uintptr) {
+		if v, err := unix.IoctlGetInt(int(fd), ioctlInq); err == nil {
+			n = v
+		}
+	})
+	return n
+}
+
+// outputReader marks when the output copier is blocked reading the PTY,
+// as opposed to passing on what it read
+type outputReader struct {
+	r       io.Reader
+	reading *atomic.Bool
+}
+
+func (o outputReader) Read(p []byte) (int, error) {
+	o.reading.Store(true)
+	n, err := o.r.Read(p)
+	o.reading.Store(false)
+	return n, err
+}
+
+// killGroup ends whatever is left of the child's process group: SIGHUP as
+// a terminal hangup would send, then SIGTERM, then SIGKILL, each once the
+// previous one has had killGrace to work
+func (s *Session) killGroup() {
+	if s.cmd == nil || s.cmd.Process == nil {
+		return
+	}
+	pgid := s.cmd.Process.Pid // The child leads its own process group
+	for _, sig := range []syscall.Signal{unix.SIGHUP, unix.SIGTERM, unix.SIGKILL} {
+		if !groupAlive(pgid) {
+			return // Nothing left
+		}
+		unix.Kill(-pgid, sig)
+		deadline := time.Now().Add(killGrace)
+		for time.Now().Before(deadline) && groupAlive(pgid) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// groupAlive reports whether anything in process group pgid is left to
+// signal. A leader that exited but hasn't been reaped, as when Close runs
+// without Wait, still keeps the group around, so where /proc shows the
+// leader as a zombie the rest of the group is looked for.
+func groupAlive(pgid int) bool {
+	if unix.Kill(-pgid, 0) != nil {
+		return false
+	}
+	if leader, ok := readProcStat(pgid); !ok || leader.state != 'Z' {
+		return true
+	}
+	return len(findProcs(func(p procStat) bool { return p.pgrp == pgid && p.state != 'Z' })) > 0
+}
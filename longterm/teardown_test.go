@@ -0,0 +1,53 @@
+package longterm
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGroupAlive(t *testing.T) {
+	if _, ok := readProcStat(os.Getpid()); !ok {
+		t.Skip("no /proc")
+	}
+	start := func(args ...string) *exec.Cmd {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return cmd
+	}
+
+	running := start("sleep", "10")
+	if !groupAlive(running.Process.Pid) {
+		t.Error("group with a running leader isn't alive")
+	}
+	running.Process.Kill()
+	running.Wait()
+
+	// A leader that exited but wasn't reaped leaves nothing to signal
+	zombie := start("true")
+	defer zombie.Wait()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if p, _ := readProcStat(zombie.Process.Pid); p.state == 'Z' {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if groupAlive(zombie.Process.Pid) {
+		t.Error("group with only a zombie leader is alive")
+	}
+
+	// Unless the rest of the group is still running
+	orphans := start("sh", "-c", "sleep 10 & exit")
+	defer syscall.Kill(-orphans.Process.Pid, syscall.SIGKILL)
+	defer orphans.Wait()
+	time.Sleep(100 * time.Millisecond)
+	if !groupAlive(orphans.Process.Pid) {
+		t.Error("group with a zombie leader and a running member isn't alive")
+	}
+}
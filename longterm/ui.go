@@ -0,0 +1,414 @@
+package longterm
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// ANSI escape code constants
+const (
+	ansiReset      = "\033[0m"
+	ansiHideCursor = "\033[?25l"
+	ansiShowCursor = "\033[?25h"
+	ansiGray       = "\033[90m"
+
+	// Modes the overlay needs: button-event mouse tracking with SGR
+	// reports, and bracketed paste so pasted text can't trigger bindings
+	ansiOverlayModes    = "\033[?1002h\033[?1006h\033[?2004h"
+	ansiOverlayModesOff = "\033[?1002l\033[?1006l\033[?2004l"
+)
+
+// Mouse controls in the overlay, by their column within the box
+const (
+	minusCol    = 2
+	sliderCol   = 6
+	sliderWidth = 20
+	plusCol     = 27
+	toggleCol   = 31
+)
+
+// uiControl is an overlay element that responds to the mouse
+type uiControl int
+
+const (
+	controlNone uiControl = iota
+	controlMinus
+	controlSlider
+	controlPlus
+	controlToggle
+)
+
+func ansiMoveCursor(row, col int) string {
+	return fmt.Sprintf("\033[%d;%dH", row, col)
+}
+
+// uiRenderer manages /dev/tty output for command mode UI. It draws over
+// a model of the real terminal so that whatever the box covers can be
+// put back cell for cell.
+type uiRenderer struct {
+	tty        *os.File
+	available  bool
+	boxWidth   int
+	escapeName string   // Key that enters command mode, for the help text
+	help       []string // Key binding help, generated from the binding table
+
+	host     *Screen  // What the real terminal shows, at its real size
+	lines    []string // Box content as last drawn; nil when hidden
+	row      int      // Top left corner of the drawn box, 0-based
+	col      int
+	controls int  // Index of the mouse controls line in lines
+	damaged  bool // Child output moved cells since the box was drawn
+}
+
+func newUIRenderer() *uiRenderer {
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		// /dev/tty unavailable - command mode will be disabled
+		return &uiRenderer{available: false, boxWidth: 40}
+	}
+	return &uiRenderer{
+		tty:       tty,
+		available: true,
+		boxWidth:  40,
+	}
+}
+
+func (ui *uiRenderer) close() {
+	if ui.tty != nil {
+		ui.tty.Close()
+	}
+}
+
+// visible reports whether the box is on screen
+func (ui *uiRenderer) visible() bool {
+	return ui.lines != nil
+}
+
+// renderBox draws the command mode UI overlay
+func (ui *uiRenderer) renderBox(st Status, numBuf NumericBuffer, errorMsg, infoMsg, recent string) {
+	if !ui.available {
+		return
+	}
+
+	// Determine mode string
+	modeStr := ""
+	if st.Mode == "real" {
+		modeStr = "(real)"
+	} else if st.Mode == "rule" {
+		modeStr = "(rule)"
+	} else if st.Delta != 0 {
+		modeStr = fmt.Sprintf("(Δ%s)", signed(st.Delta))
+	} else {
+		modeStr = "(fake)"
+	}
+
+	// Determine width mode string
+	widthStr := ""
+	if st.Mode == "real" {
+		widthStr = "real"
+	} else if st.WidthDelta != 0 {
+		widthStr = fmt.Sprintf("Δ%s", signed(st.WidthDelta))
+	} else if st.Width > 0 {
+		widthStr = "fixed"
+	} else {
+		widthStr = "passthrough"
+	}
+
+	// Box content lines. Optional ones are dropped, last first, when the
+	// box is taller than the terminal.
+	var lines []string
+	var optional []bool
+	add := func(opt bool, line string) {
+		lines = append(lines, line)
+		optional = append(optional, opt)
+	}
+	add(false, "┌──────────────────────────────────────┐")
+	add(false, "│   LONG-TERM ENABLED                  │")
+	add(false, "├──────────────────────────────────────┤")
+	add(false, fmt.Sprintf("│ Term size: %-26s│", fmt.Sprintf("%dx%d %s", st.Cols, st.Rows, modeStr)))
+	add(false, fmt.Sprintf("│ Width: %-30s│", widthStr))
+	if st.Profile != "" {
+		add(true, fmt.Sprintf("│ Profile: %-28s│", truncate(st.Profile, 28)))
+	}
+	if st.Foreground != "" {
+		fg := fmt.Sprintf("%s (%d)", st.Foreground, st.ForegroundPID)
+		add(true, fmt.Sprintf("│ Foreground: %-25s│", truncate(fg, 25)))
+	}
+	if st.Rule != "" {
+		add(true, fmt.Sprintf("│ Rule: %-31s│", truncate(st.Rule, 31)))
+	}
+	if r := st.Resources; r != nil {
+		child := "n/a"
+		if r.Tree {
+			child = fmt.Sprintf("%s  CPU %.0f%%  %d thr", formatBytes(r.RSS*1024*1024), r.CPU, r.Threads)
+		}
+		// Descendants, then long-term's own heap and PTY throughput
+		self := fmt.Sprintf("Procs: +%d  Heap: %s  PTY: %s/s", r.Descendants, formatBytes(r.Heap*1024*1024), formatBytes(r.Throughput))
+		add(true, fmt.Sprintf("│ Child: %-30s│", truncate(child, 30)))
+		add(true, fmt.Sprintf("│ %-37s│", truncate(self, 37)))
+	}
+
+	// Height buttons and slider, and the real size toggle
+	toggle := "[ ] real"
+	if st.Mode == "real" {
+		toggle = "[x] real"
+	}
+	controls := len(lines)
+	add(false, fmt.Sprintf("│ [-] %s [+] %-8s│", slider(st.Rows), toggle))
+	add(false, "│                                      │")
+
+	// Show numeric input, error or the result of the last action
+	if errorMsg != "" {
+		add(false, fmt.Sprintf("│ ERROR: %-30s│", truncate(errorMsg, 30)))
+	} else if numBuf.mode == NumericHeight {
+		input := string(numBuf.digits) + "_"
+		add(false, fmt.Sprintf("│ Enter height: %-23s│", input))
+	} else if numBuf.mode == NumericDelta {
+		input := string(numBuf.digits) + "_"
+		add(false, fmt.Sprintf("│ Enter delta: %-24s│", input))
+	} else if numBuf.mode == NumericWidth {
+		input := string(numBuf.digits) + "_"
+		add(false, fmt.Sprintf("│ Enter width: %-24s│", input))
+	} else {
+		// Normal command help
+		if recent != "" {
+			add(true, fmt.Sprintf("│ %-37s│", truncate(recent, 37)))
+		}
+		for _, line := range ui.help {
+			add(true, fmt.Sprintf("│ %-37s│", truncate(line, 37)))
+		}
+		add(true, fmt.Sprintf("│ %-37s│", truncate(ui.escapeName+": send "+ui.escapeName+" to program", 37)))
+		if infoMsg != "" {
+			add(false, fmt.Sprintf("│ %-37s│", truncate(infoMsg, 37)))
+		}
+	}
+
+	add(false, "└──────────────────────────────────────┘")
+	_, rows := ui.host.Size()
+	lines, ui.controls = fitLines(lines, optional, rows, controls)
+	ui.draw(lines)
+}
+
+// fitLines drops optional lines, last first, until lines fit in rows or
+// only required ones are left. It returns the kept lines and where line
+// i ended up.
+func fitLines(lines []string, optional []bool, rows, i int) ([]string, int) {
+	drop := len(lines) - rows
+	if drop <= 0 {
+		return lines, i
+	}
+	dropped := make([]bool, len(lines))
+	for j := len(lines) - 1; j >= 0 && drop > 0; j-- {
+		if optional[j] {
+			dropped[j] = true
+			drop--
+		}
+	}
+	var kept []string
+	at := i
+	for j, line := range lines {
+		if !dropped[j] {
+			kept = append(kept, line)
+		} else if j < i {
+			at--
+		}
+	}
+	return kept, at
+}
+
+// redraw paints the box again after child output may have covered it
+func (ui *uiRenderer) redraw() {
+	if ui.visible() {
+		ui.draw(ui.lines)
+	}
+}
+
+// draw paints lines as the box, first restoring whatever an earlier box
+// at a different position or size covered
+func (ui *uiRenderer) draw(lines []string) {
+	// Calculate position: 1/4 from top, or higher if the box wouldn't
+	// fit, right-aligned. Lines past the bottom are clipped.
+	cols, rows := ui.host.Size()
+	row := max(min(rows/4, rows-len(lines)), 0)
+	col := max(cols-ui.boxWidth-1, 0)
+
+	var buf bytes.Buffer
+	buf.WriteString(ansiHideCursor)
+	buf.WriteString(ansiOverlayModes) // Again, in case the child changed them
+	ui.suspendModes(&buf)
+	if ui.damaged {
+		ui.restore(&buf, 0, 0, cols, rows)
+	} else if ui.visible() && (row != ui.row || col != ui.col || len(lines) < len(ui.lines)) {
+		ui.restore(&buf, ui.col, ui.row, ui.boxWidth, len(ui.lines))
+	}
+	buf.WriteString(ansiReset)
+	for i, line := range lines {
+		if row+i >= rows {
+			break
+		}
+		buf.WriteString(ansiMoveCursor(row+i+1, col+1))
+		buf.WriteString(line)
+	}
+	ui.restoreCursor(&buf)
+
+	ui.lines, ui.row, ui.col = lines, row, col
+	ui.damaged = false
+	ui.tty.Write(buf.Bytes())
+}
+
+// clearBox removes the UI overlay, putting back the cells it covered
+func (ui *uiRenderer) clearBox() {
+	if !ui.available || !ui.visible() {
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(ansiHideCursor)
+	ui.suspendModes(&buf)
+	if ui.damaged {
+		cols, rows := ui.host.Size()
+		ui.restore(&buf, 0, 0, cols, rows)
+	} else {
+		ui.restore(&buf, ui.col, ui.row, ui.boxWidth, len(ui.lines))
+	}
+	ui.restoreCursor(&buf)
+	buf.WriteString(ansiOverlayModesOff)
+	buf.WriteString(childModes(ui.host.Modes()))
+
+	ui.lines = nil
+	ui.damaged = false
+	ui.tty.Write(buf.Bytes())
+}
+
+// finish leaves the terminal with the cursor showing and the overlay's
+// modes off, whatever state the child or a crash left it in
+func (ui *uiRenderer) finish() {
+	if ui.available {
+		ui.tty.WriteString(ansiOverlayModesOff + ansiShowCursor)
+	}
+}
+
+// restore repaints a region of the real terminal from the host model
+func (ui *uiRenderer) restore(buf *bytes.Buffer, x, y, width, height int) {
+	cols, rows := ui.host.Size()
+	for row := y; row < y+height && row < rows; row++ {
+		buf.WriteString(ansiMoveCursor(row+1, x+1))
+		var pen Style
+		buf.WriteString(ansiReset)
+		for col := x; col < x+width && col < cols; col++ {
+			c := ui.host.Cell(col, row)
+			if c.Style != pen {
+				buf.WriteString(sgrSequence(c.Style))
+				pen = c.Style
+			}
+			if c.Rune == 0 {
+				buf.WriteByte(' ')
+			} else {
+				buf.WriteRune(c.Rune)
+			}
+		}
+	}
+}
+
+// suspendModes turns off the child's modes that would displace the box:
+// origin mode makes positions relative to the scroll region, and insert
+// mode pushes the child's text aside
+func (ui *uiRenderer) suspendModes(buf *bytes.Buffer) {
+	modes := ui.host.Modes()
+	if modes.Origin {
+		buf.WriteString("\033[?6l")
+	}
+	if modes.Insert {
+		buf.WriteString("\033[4l")
+	}
+}
+
+// restoreCursor puts the cursor, pen, cursor visibility and suspended
+// modes back to where the child left them, without touching the child's
+// DECSC slot
+func (ui *uiRenderer) restoreCursor(buf *bytes.Buffer) {
+	x, y, visible := ui.host.Cursor()
+	modes := ui.host.Modes()
+	if modes.Origin {
+		top, _ := ui.host.ScrollRegion()
+		buf.WriteString("\033[?6h")
+		y -= top
+	}
+	if modes.Insert {
+		buf.WriteString("\033[4h")
+	}
+	buf.WriteString(ansiMoveCursor(y+1, x+1))
+	buf.WriteString(sgrSequence(ui.host.Pen()))
+	if visible {
+		buf.WriteString(ansiShowCursor)
+	}
+}
+
+// controlAt returns the overlay control at a screen cell, and the slider
+// position nearest to it
+func (ui *uiRenderer) controlAt(x, y int) (uiControl, int) {
+	pos := min(max(x-ui.col-sliderCol, 0), sliderWidth-1)
+	if !ui.visible() || y-ui.row != ui.controls {
+		return controlNone, pos
+	}
+	switch col := x - ui.col; {
+	case col >= minusCol && col < minusCol+3:
+		return controlMinus, pos
+	case col >= sliderCol && col < sliderCol+sliderWidth:
+		return controlSlider, pos
+	case col >= plusCol && col < plusCol+3:
+		return controlPlus, pos
+	case col >= toggleCol && col < toggleCol+8:
+		return controlToggle, pos
+	}
+	return controlNone, pos
+}
+
+// slider draws height on a logarithmic scale from 1 to 9999
+func slider(height int) string {
+	knob := int(math.Round(math.Log10(float64(max(height, 1))) / 4 * (sliderWidth - 1)))
+	knob = min(knob, sliderWidth-1)
+	return strings.Repeat("─", knob) + "●" + strings.Repeat("─", sliderWidth-1-knob)
+}
+
+// sliderHeight is the height at a slider position
+func sliderHeight(pos int) int {
+	h := int(math.Round(math.Pow(10, float64(pos)*4/(sliderWidth-1))))
+	return min(max(h, 1), 9999)
+}
+
+// childModes re-enables the child's modes in m that the overlay's own
+// modes replaced
+func childModes(m Modes) string {
+	s := ""
+	if m.MouseTracking != 0 {
+		s += fmt.Sprintf("\033[?%dh", m.MouseTracking)
+	}
+	if m.MouseEncoding != 0 {
+		s += fmt.Sprintf("\033[?%dh", m.MouseEncoding)
+	}
+	if m.BracketedPaste {
+		s += "\033[?2004h"
+	}
+	return s
+}
+
+// signed formats n with an explicit sign
+func signed(n int) string {
+	if n < 0 {
+		return fmt.Sprintf("%d", n)
+	}
+	return fmt.Sprintf("+%d", n)
+}
+
+// truncate shortens s to at most n runes
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
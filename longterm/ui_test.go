@@ -0,0 +1,29 @@
+package longterm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFitLines(t *testing.T) {
+	lines := []string{"top", "a", "b", "controls", "c", "d", "bottom"}
+	optional := []bool{false, true, true, false, true, true, false}
+	tests := []struct {
+		rows     int
+		want     []string
+		controls int
+	}{
+		{10, lines, 3},
+		{7, lines, 3},
+		{6, []string{"top", "a", "b", "controls", "c", "bottom"}, 3},
+		{4, []string{"top", "a", "controls", "bottom"}, 2},
+		{3, []string{"top", "controls", "bottom"}, 1},
+		{1, []string{"top", "controls", "bottom"}, 1},
+	}
+	for _, tt := range tests {
+		got, controls := fitLines(lines, optional, tt.rows, 3)
+		if !reflect.DeepEqual(got, tt.want) || controls != tt.controls {
+			t.Errorf("fitLines(%d rows) = %q, %d, want %q, %d", tt.rows, got, controls, tt.want, tt.controls)
+		}
+	}
+}
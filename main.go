@@ -1,852 +1,321 @@
 package main
 
 import (
-	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
-	"os/signal"
-	"runtime"
-	"strconv"
-	"strings"
-	"sync/atomic"
-	"syscall"
 	"time"
 
-	"github.com/creack/pty"
-	"golang.org/x/term"
+	"github.com/brandon-fryslie/long-term/longterm"
 )
 
-// Mode represents the current operating mode
-type Mode uint32
-
-const (
-	ModeNormal  Mode = 0 // Normal I/O passthrough
-	ModeCommand Mode = 1 // Command mode (UI active, intercept input)
-)
-
-// KeyCode represents parsed keyboard input
-type KeyCode int
-
-const (
-	KeyUnknown KeyCode = iota
-	KeyChar            // Regular character (a-z, 0-9, space, etc)
-	KeyESC
-	KeyUp
-	KeyDown
-	KeyLeft
-	KeyRight
-	KeyBackspace
-	KeyEnter
-)
-
-// KeyEvent represents a parsed keyboard event
-type KeyEvent struct {
-	Code      KeyCode
-	Char      rune // Valid when Code == KeyChar
-	Shift     bool // Modifier flags
-	Ctrl      bool
-	ShiftCtrl bool
-}
-
-// NumericMode tracks numeric input state
-type NumericMode int
-
-const (
-	NumericNone   NumericMode = iota
-	NumericHeight             // 'n' pressed - entering absolute height
-	NumericDelta              // 'd' pressed - entering delta
-)
-
-// NumericBuffer accumulates numeric input
-type NumericBuffer struct {
-	mode   NumericMode
-	digits []rune
-}
-
-func (nb *NumericBuffer) reset() {
-	nb.mode = NumericNone
-	nb.digits = nil
-}
-
-func (nb *NumericBuffer) append(r rune) {
-	nb.digits = append(nb.digits, r)
-}
-
-func (nb *NumericBuffer) backspace() {
-	if len(nb.digits) > 0 {
-		nb.digits = nb.digits[:len(nb.digits)-1]
-	}
-}
-
-func (nb *NumericBuffer) value() (int, error) {
-	if len(nb.digits) == 0 {
-		return 0, fmt.Errorf("empty input")
-	}
-	s := string(nb.digits)
-	val, err := strconv.Atoi(s)
-	if err != nil {
-		return 0, err
-	}
-
-	// Validate range
-	if nb.mode == NumericHeight {
-		if val < 1 || val > 9999 {
-			return 0, fmt.Errorf("height must be 1-9999")
-		}
-	} else if nb.mode == NumericDelta {
-		// Delta requires +/- prefix
-		if len(s) == 0 || (s[0] != '+' && s[0] != '-') {
-			return 0, fmt.Errorf("delta requires +/- prefix")
-		}
-		if val < -9999 || val > 9999 {
-			return 0, fmt.Errorf("delta must be ±1 to ±9999")
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ctl":
+			os.Exit(runCtl(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "snapshot":
+			os.Exit(runSnapshot(os.Args[2:]))
+		case "state":
+			os.Exit(runState(os.Args[2:]))
 		}
 	}
-	return val, nil
-}
-
-// ANSI escape code constants
-const (
-	ansiReset         = "\033[0m"
-	ansiHideCursor    = "\033[?25l"
-	ansiShowCursor    = "\033[?25h"
-	ansiSaveCursor    = "\033[s"
-	ansiRestoreCursor = "\033[u"
-	ansiClearLine     = "\033[2K"
-	ansiGray          = "\033[90m"
-)
-
-func ansiMoveCursor(row, col int) string {
-	return fmt.Sprintf("\033[%d;%dH", row, col)
-}
-
-// getMemoryUsage returns memory in MB for the current process
-func getMemoryUsage() float64 {
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	return float64(m.Alloc) / 1024 / 1024
-}
 
-// getProcessMemory returns memory in MB for a given PID by reading /proc
-func getProcessMemory(pid int) (float64, error) {
-	statusFile := fmt.Sprintf("/proc/%d/status", pid)
-	data, err := os.ReadFile(statusFile)
-	if err != nil {
-		return 0, err
+	wf := newWrapperFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] -- command [args...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s ctl [--socket PATH] status|set-height N|set-delta ±N|set-width N|set-width-delta ±N|profile NAME|toggle|reset\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s replay [-speed N] [-idle-limit D] [-clamp] FILE\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s snapshot [-format html|svg|ansi] [-o FILE] [flags] -- command [args...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s state list|clear [COMMAND...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Wraps a command with a PTY that reports a fake terminal height.\n")
+		fmt.Fprintf(os.Stderr, "Width is passed through from the real terminal unless -width or -width-delta is set.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	// Look for VmRSS (resident set size) in KB
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "VmRSS:") {
-			fields := strings.Fields(line)
-			if len(fields) >= 2 {
-				var kb int
-				fmt.Sscanf(fields[1], "%d", &kb)
-				return float64(kb) / 1024, nil
-			}
-		}
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(1)
 	}
-	return 0, fmt.Errorf("VmRSS not found")
-}
-
-// uiRenderer manages /dev/tty output for command mode UI
-type uiRenderer struct {
-	tty       *os.File
-	available bool
-	boxWidth  int
-}
 
-func newUIRenderer() *uiRenderer {
-	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	opts, err := wf.options(args)
 	if err != nil {
-		// /dev/tty unavailable - command mode will be disabled
-		return &uiRenderer{available: false, boxWidth: 40}
-	}
-	return &uiRenderer{
-		tty:       tty,
-		available: true,
-		boxWidth:  40,
-	}
-}
-
-func (ui *uiRenderer) close() {
-	if ui.tty != nil {
-		ui.tty.Close()
-	}
-}
-
-// renderBox draws the command mode UI overlay
-func (ui *uiRenderer) renderBox(termWidth, termHeight, currentHeight, currentDelta int, useReal bool, numBuf NumericBuffer, errorMsg string) {
-	if !ui.available {
-		return
-	}
-
-	// Calculate position: 1/4 from top, right-aligned
-	boxRow := termHeight / 4
-	boxCol := termWidth - ui.boxWidth
-	if boxCol < 1 {
-		boxCol = 1
-	}
-
-	// Build UI content in buffer
-	var buf bytes.Buffer
-
-	// Save cursor position first, then hide
-	buf.WriteString(ansiSaveCursor)
-	buf.WriteString(ansiHideCursor)
-
-	// Determine mode string
-	modeStr := ""
-	if useReal {
-		modeStr = "(real)"
-	} else if currentDelta != 0 {
-		sign := "+"
-		if currentDelta < 0 {
-			sign = ""
-		}
-		modeStr = fmt.Sprintf("(Δ%s%d)", sign, currentDelta)
-	} else {
-		modeStr = "(fake)"
-	}
-
-	// Box content lines
-	lines := []string{
-		"┌──────────────────────────────────────┐",
-		"│   LONG-TERM ENABLED                  │",
-		"├──────────────────────────────────────┤",
-		fmt.Sprintf("│ Term size: %dx%d %-18s │", termWidth, termHeight, modeStr),
-		"│                                      │",
-	}
-
-	// Show numeric input or error
-	if errorMsg != "" {
-		lines = append(lines, fmt.Sprintf("│ ERROR: %-30s│", errorMsg))
-	} else if numBuf.mode == NumericHeight {
-		input := string(numBuf.digits) + "_"
-		lines = append(lines, fmt.Sprintf("│ Enter height: %-23s│", input))
-	} else if numBuf.mode == NumericDelta {
-		input := string(numBuf.digits) + "_"
-		lines = append(lines, fmt.Sprintf("│ Enter delta: %-24s│", input))
-	} else {
-		// Normal command help
-		lines = append(lines,
-			"│ UP/DOWN: ±1  Shift: ±20  Ctrl: ±200  │",
-			"│ n: set height  d: set delta          │",
-			"│ space: toggle  r: reset  ESC: exit   │",
-		)
-	}
-
-	lines = append(lines, "└──────────────────────────────────────┘")
-
-	// Render each line
-	for i, line := range lines {
-		row := boxRow + i
-		buf.WriteString(ansiMoveCursor(row, boxCol))
-		buf.WriteString(ansiClearLine)
-		buf.WriteString(line)
+		fmt.Fprintf(os.Stderr, "loooooooong-term: %v\n", err)
+		os.Exit(1)
 	}
-
-	// Restore cursor and show
-	buf.WriteString(ansiRestoreCursor)
-	buf.WriteString(ansiShowCursor)
-
-	// Atomic write
-	ui.tty.Write(buf.Bytes())
+	exit(run(args, opts, wf, nil))
 }
 
-// clearBox removes the UI overlay
-func (ui *uiRenderer) clearBox(termWidth, termHeight int) {
-	if !ui.available {
-		return
-	}
-
-	boxRow := termHeight / 4
-	boxCol := termWidth - ui.boxWidth
-	if boxCol < 1 {
-		boxCol = 1
+// exit reports a session error and exits with the child's status, as a
+// shell would report it
+func exit(err error) {
+	if err == nil {
+		os.Exit(0)
 	}
-
-	var buf bytes.Buffer
-	buf.WriteString(ansiSaveCursor)
-	buf.WriteString(ansiHideCursor)
-
-	// Clear approximately 10 lines where box was
-	for i := 0; i < 10; i++ {
-		row := boxRow + i
-		buf.WriteString(ansiMoveCursor(row, boxCol))
-		buf.WriteString(ansiClearLine)
+	fmt.Fprintf(os.Stderr, "loooooooong-term: %v\n", err)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		os.Exit(longterm.ExitStatus(exitErr.ProcessState))
 	}
-
-	buf.WriteString(ansiRestoreCursor)
-	buf.WriteString(ansiShowCursor)
-
-	ui.tty.Write(buf.Bytes())
+	os.Exit(1)
 }
 
-// keyboardParser reads stdin and emits KeyEvent structs
-type keyboardParser struct {
-	eventChan  chan KeyEvent
-	buf        []byte
-	state      int // 0=idle, 1=saw ESC, 2=saw ESC[
-	lastESC    time.Time
-	escTimeout time.Duration
+// wrapperFlags are the flags shared by every command that wraps a program
+type wrapperFlags struct {
+	fs             *flag.FlagSet
+	height         *int
+	heightDelta    *int
+	width          *int
+	widthDelta     *int
+	socket         *string
+	noSocket       *bool
+	record         *string
+	headless       *bool
+	dump           *string
+	profile        *string
+	snapshots      *bool
+	snapshotFormat *string
+	snapshotDir    *string
+	escapeKey      *string
+	escapeCount    *int
+	escapeWindow   *time.Duration
+	remember       *bool
+	rememberArgv   *bool
+	signals        *string
+	exitReport     *string
+	stdin          *string
 }
 
-func newKeyboardParser() *keyboardParser {
-	return &keyboardParser{
-		eventChan:  make(chan KeyEvent, 10),
-		buf:        make([]byte, 0, 16),
-		escTimeout: 100 * time.Millisecond,
+func newWrapperFlags(fs *flag.FlagSet) *wrapperFlags {
+	return &wrapperFlags{
+		fs:             fs,
+		height:         fs.Int("height", 10000, "fake terminal height to report to the wrapped program (if set, disables delta mode)"),
+		heightDelta:    fs.Int("delta", 2000, "report real_height + delta (positive adds rows, negative subtracts; optional + sign for positive values)"),
+		width:          fs.Int("width", 0, "fake terminal width to report to the wrapped program (default: real width)"),
+		widthDelta:     fs.Int("width-delta", 0, "report real_width + delta (overrides -width if set)"),
+		socket:         fs.String("socket", "", "control socket path (default: $XDG_RUNTIME_DIR/long-term-<pid>.sock)"),
+		noSocket:       fs.Bool("no-socket", false, "disable the control socket"),
+		record:         fs.String("record", "", "record the session to `FILE` in asciicast v2 format"),
+		headless:       fs.Bool("headless", false, "run against an in-memory screen instead of the real terminal and print its final contents"),
+		dump:           fs.String("dump", "", "with -headless, write the final screen to `FILE` instead of stdout"),
+		profile:        fs.String("profile", "", "apply a named profile from the config file (flags override its values)"),
+		snapshots:      fs.Bool("snapshots", false, "keep a model of the wrapped program's screen so s in command mode can save snapshots (implied by -snapshot-format and -snapshot-dir)"),
+		snapshotFormat: fs.String("snapshot-format", longterm.FormatHTML, "format of snapshots taken from command mode: html, svg or ansi"),
+		snapshotDir:    fs.String("snapshot-dir", ".", "directory for snapshots taken from command mode"),
+		escapeKey:      fs.String("escape-key", longterm.DefaultEscapeKey, "key that enters command mode: C-x, M-x, F1-F12, 0xNN or a character"),
+		escapeCount:    fs.Int("escape-count", longterm.DefaultEscapeCount, "number of escape key presses that enter command mode"),
+		escapeWindow:   fs.Duration("escape-window", longterm.DefaultEscapeWindow, "time within which the escape key presses must occur"),
+		remember:       fs.Bool("remember", false, "start with the size last chosen in command mode for this command, and remember the size chosen this time"),
+		rememberArgv:   fs.Bool("remember-argv", false, "with -remember, key the size by the full command line instead of the program name"),
+		signals:        fs.String("signals", "", "comma-separated `signals` to forward to the wrapped program's foreground job, or none (default TERM,HUP,USR1,USR2, plus INT when stdin isn't a terminal)"),
+		exitReport:     fs.String("exit-report", "", "write a JSON report of how the wrapped program exited to `FILE`"),
+		stdin:          fs.String("stdin", longterm.StdinPTY, "how the wrapped program gets stdin: pty, like typed input, or pipe, as a pipe of its own (stdin must not be a terminal)"),
 	}
 }
 
-// Write implements io.Writer to observe stdin bytes
-func (kp *keyboardParser) Write(p []byte) (n int, err error) {
-	for _, b := range p {
-		kp.processByte(b)
+// options builds session options from the config file and flags
+func (wf *wrapperFlags) options(args []string) (longterm.Options, error) {
+	cfg, err := longterm.LoadConfig(longterm.ConfigPath())
+	if err != nil {
+		return longterm.Options{}, err
 	}
-	return len(p), nil
-}
-
-func (kp *keyboardParser) processByte(b byte) {
-	now := time.Now()
 
-	// Check for ESC timeout
-	if kp.state > 0 && !kp.lastESC.IsZero() && now.Sub(kp.lastESC) > kp.escTimeout {
-		// Timeout - emit standalone ESC
-		kp.eventChan <- KeyEvent{Code: KeyESC}
-		kp.state = 0
-		kp.buf = kp.buf[:0]
+	// Default mode: delta with 2000
+	opts := longterm.Options{
+		Mode:          longterm.SizeDelta,
+		Value:         2000,
+		Profiles:      cfg.Profiles,
+		Rules:         cfg.Rules,
+		HandleSignals: true,
 	}
 
-	switch kp.state {
-	case 0: // Idle
-		if b == 0x1B { // ESC
-			kp.state = 1
-			kp.lastESC = now
-		} else if b == 0x7F { // Backspace
-			kp.eventChan <- KeyEvent{Code: KeyBackspace}
-		} else if b == '\r' || b == '\n' {
-			kp.eventChan <- KeyEvent{Code: KeyEnter}
-		} else if b >= 0x20 && b < 0x7F { // Printable ASCII
-			kp.eventChan <- KeyEvent{Code: KeyChar, Char: rune(b)}
-		}
-		// Ignore other control characters
+	// Apply the first rule matching the wrapped command
+	if r, ok := longterm.MatchRule(cfg.Rules, args); ok {
+		opts = r.Apply(opts, cfg.Profiles)
+	}
 
-	case 1: // Saw ESC
-		if b == '[' {
-			kp.state = 2
-			kp.buf = kp.buf[:0]
-		} else {
-			// Not a sequence, emit ESC and process this byte
-			kp.eventChan <- KeyEvent{Code: KeyESC}
-			kp.state = 0
-			kp.processByte(b) // Reprocess current byte
+	// Restore the size last chosen for this command. The profile and
+	// explicit flags below still take precedence.
+	if *wf.remember {
+		st, err := longterm.LoadState(longterm.StatePath())
+		if err != nil {
+			return opts, err
 		}
-
-	case 2: // Saw ESC[
-		kp.buf = append(kp.buf, b)
-		// Check for complete sequences
-		if b >= 0x40 && b <= 0x7E { // Final byte of sequence
-			kp.parseSequence()
-			kp.state = 0
-			kp.buf = kp.buf[:0]
+		if sz, ok := st.Sizes[longterm.StateKey(args, *wf.rememberArgv)]; ok {
+			opts = sz.Apply(opts)
 		}
 	}
-}
-
-func (kp *keyboardParser) parseSequence() {
-	seq := string(kp.buf)
-
-	// Parse arrow keys with modifiers
-	// Basic arrows: A=up, B=down, C=right, D=left
-	// Modified: ESC[1;XY where X=modifier, Y=direction
-	// Modifiers: 2=Shift, 5=Ctrl, 6=Shift+Ctrl
-
-	var event KeyEvent
-
-	switch seq {
-	case "A":
-		event.Code = KeyUp
-	case "B":
-		event.Code = KeyDown
-	case "C":
-		event.Code = KeyRight
-	case "D":
-		event.Code = KeyLeft
-	case "1;2A":
-		event = KeyEvent{Code: KeyUp, Shift: true}
-	case "1;2B":
-		event = KeyEvent{Code: KeyDown, Shift: true}
-	case "1;5A":
-		event = KeyEvent{Code: KeyUp, Ctrl: true}
-	case "1;5B":
-		event = KeyEvent{Code: KeyDown, Ctrl: true}
-	case "1;6A":
-		event = KeyEvent{Code: KeyUp, ShiftCtrl: true}
-	case "1;6B":
-		event = KeyEvent{Code: KeyDown, ShiftCtrl: true}
-	default:
-		event.Code = KeyUnknown
-	}
-
-	if event.Code != KeyUnknown {
-		kp.eventChan <- event
-	}
-}
 
-func main() {
-	height := flag.Int("height", 10000, "fake terminal height to report to the wrapped program (if set, disables delta mode)")
-	heightDelta := flag.Int("delta", 2000, "report real_height + delta (positive adds rows, negative subtracts; optional + sign for positive values)")
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [flags] -- command [args...]\n\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Wraps a command with a PTY that reports a fake terminal height.\n")
-		fmt.Fprintf(os.Stderr, "Width is passed through from the real terminal.\n\n")
-		fmt.Fprintf(os.Stderr, "Flags:\n")
-		flag.PrintDefaults()
+	// Layer the selected profile on top of the defaults
+	if *wf.profile != "" {
+		p, ok := cfg.Profiles[*wf.profile]
+		if !ok {
+			return opts, fmt.Errorf("unknown profile %q", *wf.profile)
+		}
+		opts = p.Apply(opts)
+		opts.Profile = *wf.profile
+		opts.Real = false
 	}
-	flag.Parse()
 
-	args := flag.Args()
-	if len(args) == 0 {
-		flag.Usage()
-		os.Exit(1)
-	}
+	opts.EscapeKey = cfg.Escape.Key
+	opts.EscapeCount = cfg.Escape.Count
+	opts.EscapeWindow = cfg.Escape.Window
+	opts.Bindings = cfg.Bindings
 
-	// Determine which mode: absolute height, delta offset, or default delta
+	// Explicit flags override the profile and config
 	heightSet := false
 	deltaSet := false
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == "height" {
+	wf.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "height":
 			heightSet = true
-		}
-		if f.Name == "delta" {
+		case "delta":
 			deltaSet = true
+		case "width":
+			opts.Width = *wf.width
+			opts.WidthDelta = 0
+		case "width-delta":
+			opts.WidthDelta = *wf.widthDelta
+		case "escape-key":
+			opts.EscapeKey = *wf.escapeKey
+		case "escape-count":
+			opts.EscapeCount = *wf.escapeCount
+		case "escape-window":
+			opts.EscapeWindow = *wf.escapeWindow
+		case "snapshot-format", "snapshot-dir":
+			opts.Snapshots = true
 		}
 	})
 
-	var mode int
-	var modeValue int
-
 	if heightSet {
 		// Absolute mode: use the specified height, ignore delta
-		mode = 0 // absolute
-		modeValue = *height
+		opts.Mode = longterm.SizeAbsolute
+		opts.Value = *wf.height
+		opts.Real = false
 	} else if deltaSet {
 		// Delta mode: use the specified delta value
-		mode = 1 // delta
-		modeValue = *heightDelta
-	} else {
-		// Default mode: delta with 2000
-		mode = 1 // delta
-		modeValue = 2000
+		opts.Mode = longterm.SizeDelta
+		opts.Value = *wf.heightDelta
+		opts.Real = false
 	}
 
-	if err := run(args, mode, modeValue); err != nil {
-		fmt.Fprintf(os.Stderr, "loooooooong-term: %v\n", err)
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			os.Exit(exitErr.ExitCode())
+	if *wf.signals != "" {
+		sigs, err := longterm.ParseSignals(*wf.signals)
+		if err != nil {
+			return opts, fmt.Errorf("-signals: %w", err)
 		}
-		os.Exit(1)
+		opts.Signals = sigs
 	}
-}
 
-// magicDetector observes stdin for Ctrl+\ pressed 3 times within 500ms
-type magicDetector struct {
-	toggleChan  chan bool
-	lastPress   time.Time
-	pressCount  int
-	magicByte   byte
-	window      time.Duration
-	targetCount int
-}
-
-func newMagicDetector(toggleChan chan bool) *magicDetector {
-	return &magicDetector{
-		toggleChan:  toggleChan,
-		magicByte:   0x1C, // Ctrl+\ (SIGQUIT character)
-		window:      500 * time.Millisecond,
-		targetCount: 3,
-	}
-}
-
-func (m *magicDetector) Write(p []byte) (n int, err error) {
-	now := time.Now()
-
-	// Check if we need to reset the counter (window expired)
-	if !m.lastPress.IsZero() && now.Sub(m.lastPress) > m.window {
-		m.pressCount = 0
+	switch *wf.stdin {
+	case longterm.StdinPTY, longterm.StdinPipe:
+		opts.StdinMode = *wf.stdin
+	default:
+		return opts, fmt.Errorf("-stdin: unknown mode %q", *wf.stdin)
 	}
 
-	// Count occurrences of magic byte in this chunk
-	count := bytes.Count(p, []byte{m.magicByte})
-	if count > 0 {
-		m.pressCount += count
-		m.lastPress = now
+	opts.Headless = *wf.headless
+	opts.Snapshots = opts.Snapshots || *wf.snapshots
+	opts.SnapshotFormat = *wf.snapshotFormat
+	opts.SnapshotDir = *wf.snapshotDir
 
-		if m.pressCount >= m.targetCount {
-			// Trigger toggle
-			select {
-			case m.toggleChan <- true:
-			default:
-			}
-			m.pressCount = 0 // Reset after triggering
+	if !*wf.noSocket {
+		opts.ControlSocket = *wf.socket
+		if opts.ControlSocket == "" {
+			opts.ControlSocket = longterm.DefaultSocketPath()
 		}
 	}
-
-	return len(p), nil
+	return opts, nil
 }
 
-func run(args []string, mode, modeValue int) error {
-	// Get the real terminal size
-	realWidth, realHeight, err := term.GetSize(int(os.Stdin.Fd()))
-	if err != nil {
-		// Default to 80 if we can't get the size
-		realWidth = 80
-		realHeight = 24
-	}
-
-	// Save initial mode and value for reset command
-	initialMode := mode
-	initialModeValue := modeValue
-
-	// Height and delta: single source of truth (atomic for lock-free access)
-	var currentHeight atomic.Int32
-	var currentDelta atomic.Int32
-	var modeState atomic.Int32
-	modeState.Store(int32(mode)) // 0 = absolute, 1 = delta
-
-	// Initialize based on mode
-	var effectiveHeight int
-	if mode == 0 {
-		// Absolute mode: use the specified height
-		currentHeight.Store(int32(modeValue))
-		currentDelta.Store(0)
-		effectiveHeight = modeValue
-	} else {
-		// Delta mode: use the specified delta
-		currentHeight.Store(int32(realHeight + modeValue))
-		currentDelta.Store(int32(modeValue))
-		effectiveHeight = realHeight + modeValue
-		if effectiveHeight < 1 {
-			effectiveHeight = 1
+// run executes a session to completion. atExit, if set, runs after the
+// child exits and before the terminal is restored.
+func run(args []string, opts longterm.Options, wf *wrapperFlags, atExit func(*longterm.Session) error) error {
+	if *wf.record != "" {
+		f, err := os.Create(*wf.record)
+		if err != nil {
+			return fmt.Errorf("failed to create recording: %w", err)
 		}
+		defer f.Close()
+		opts.Record = f
 	}
 
-	// Mode state: single source of truth
-	var currentMode atomic.Uint32
-	currentMode.Store(uint32(ModeNormal))
-
-	// Toggle mode: false = use fake/delta height, true = use real height
-	var useRealSize atomic.Bool
-	useRealSize.Store(false)
-
-	// Numeric input state
-	var numericBuf NumericBuffer
-	var lastError string
-
-	// Create UI renderer
-	ui := newUIRenderer()
-	defer ui.close()
-
-	if !ui.available {
-		fmt.Fprintf(os.Stderr, "Warning: /dev/tty unavailable, command mode UI disabled\n")
+	s := longterm.NewSession(args, opts)
+	defer s.Close()
+	if err := s.Start(); err != nil {
+		return err
 	}
+	// Wait for the command to finish
+	err := s.Wait()
 
-	// Create keyboard parser
-	kbParser := newKeyboardParser()
-
-	// Create SIGWINCH channel first (needed by toggle handler)
-	sigwinch := make(chan os.Signal, 1)
-
-	// UI refresh trigger channel
-	refreshUI := make(chan bool, 10)
-
-	// Helper function to trigger UI refresh
-	triggerRefresh := func() {
-		select {
-		case refreshUI <- true:
-		default:
+	if *wf.remember {
+		if sz, ok := s.ChosenSize(); ok {
+			if saveErr := rememberSize(longterm.StateKey(args, *wf.rememberArgv), sz); saveErr != nil && err == nil {
+				err = saveErr
+			}
 		}
 	}
 
-	// Channel for entering command mode (Ctrl+\ x3)
-	enterCommandChan := make(chan bool, 1)
-	go func() {
-		for range enterCommandChan {
-			currentMode.Store(uint32(ModeCommand))
-			triggerRefresh()
+	if *wf.exitReport != "" {
+		if reportErr := writeExitReport(s, *wf.exitReport); reportErr != nil && err == nil {
+			err = reportErr
 		}
-	}()
-
-	// UI refresh goroutine
-	go func() {
-		ticker := time.NewTicker(100 * time.Millisecond)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				// Refresh if in command mode
-				if Mode(currentMode.Load()) == ModeCommand {
-					w, h, err := term.GetSize(int(os.Stdin.Fd()))
-					if err == nil {
-						ui.renderBox(w, h, int(currentHeight.Load()), int(currentDelta.Load()),
-							useRealSize.Load(), numericBuf, lastError)
-					}
-				}
-			case <-refreshUI:
-				// Immediate refresh requested
-				if Mode(currentMode.Load()) == ModeCommand {
-					w, h, err := term.GetSize(int(os.Stdin.Fd()))
-					if err == nil {
-						ui.renderBox(w, h, int(currentHeight.Load()), int(currentDelta.Load()),
-							useRealSize.Load(), numericBuf, lastError)
-					}
-				}
-			}
-		}
-	}()
-
-	// Command handler goroutine - processes keyboard events in command mode
-	go func() {
-		for event := range kbParser.eventChan {
-			if Mode(currentMode.Load()) != ModeCommand {
-				continue
-			}
-
-			lastError = "" // Clear error on new input
-
-			// Handle numeric input mode
-			if numericBuf.mode != NumericNone {
-				switch event.Code {
-				case KeyESC:
-					numericBuf.reset()
-					triggerRefresh()
-				case KeyBackspace:
-					numericBuf.backspace()
-					triggerRefresh()
-				case KeyEnter:
-					val, err := numericBuf.value()
-					if err == nil {
-						// Apply value
-						if numericBuf.mode == NumericHeight {
-							currentHeight.Store(int32(val))
-							currentDelta.Store(0) // Clear delta when setting absolute
-						} else if numericBuf.mode == NumericDelta {
-							currentDelta.Store(int32(val))
-						}
-						numericBuf.reset()
-						// Trigger resize
-						sigwinch <- syscall.SIGWINCH
-						// Exit command mode after applying value
-						currentMode.Store(uint32(ModeNormal))
-						// Clear UI
-						w, h, err := term.GetSize(int(os.Stdin.Fd()))
-						if err == nil {
-							ui.clearBox(w, h)
-						}
-					} else {
-						lastError = err.Error()
-						triggerRefresh()
-					}
-				case KeyChar:
-					if event.Char >= '0' && event.Char <= '9' {
-						numericBuf.append(event.Char)
-					} else if numericBuf.mode == NumericDelta && len(numericBuf.digits) == 0 {
-						if event.Char == '+' || event.Char == '-' {
-							numericBuf.append(event.Char)
-						}
-					}
-					triggerRefresh()
-				}
-				continue
-			}
-
-			// Normal command mode handling
-			switch event.Code {
-			case KeyESC, KeyEnter:
-				// Exit command mode
-				currentMode.Store(uint32(ModeNormal))
-				numericBuf.reset()
-				// Clear UI
-				w, h, err := term.GetSize(int(os.Stdin.Fd()))
-				if err == nil {
-					ui.clearBox(w, h)
-				}
-
-			case KeyChar:
-				switch event.Char {
-				case 'n':
-					numericBuf.mode = NumericHeight
-					numericBuf.digits = nil
-					triggerRefresh()
-				case 'd':
-					numericBuf.mode = NumericDelta
-					numericBuf.digits = nil
-					triggerRefresh()
-				case ' ':
-					// Toggle real/fake size
-					current := useRealSize.Load()
-					useRealSize.Store(!current)
-					sigwinch <- syscall.SIGWINCH
-					triggerRefresh()
-				case 'r':
-					// Reset to defaults from flags
-					modeState.Store(int32(initialMode))
-					if initialMode == 0 {
-						// Absolute mode
-						currentHeight.Store(int32(initialModeValue))
-						currentDelta.Store(0)
-					} else {
-						// Delta mode
-						currentDelta.Store(int32(initialModeValue))
-						currentHeight.Store(int32(realHeight + initialModeValue))
-					}
-					useRealSize.Store(false)
-					sigwinch <- syscall.SIGWINCH
-					triggerRefresh()
-				}
-
-			case KeyUp, KeyDown:
-				// Adjust height based on modifiers
-				delta := 1
-				if event.Shift {
-					delta = 20
-				} else if event.Ctrl {
-					delta = 200
-				} else if event.ShiftCtrl {
-					delta = 200
-				}
-				if event.Code == KeyDown {
-					delta = -delta
-				}
+	}
 
-				// Apply delta
-				if currentDelta.Load() != 0 {
-					currentDelta.Add(int32(delta))
-				} else {
-					newHeight := int(currentHeight.Load()) + delta
-					if newHeight < 1 {
-						newHeight = 1
-					} else if newHeight > 9999 {
-						newHeight = 9999
-					}
-					currentHeight.Store(int32(newHeight))
-				}
-				sigwinch <- syscall.SIGWINCH
-				triggerRefresh()
-			}
+	if opts.Headless {
+		if dumpErr := writeDump(s.Screen().Text(), *wf.dump); dumpErr != nil && err == nil {
+			err = dumpErr
 		}
-	}()
-
-	// Create the command, using shell if needed for aliases
-	var cmd *exec.Cmd
-	if _, err := exec.LookPath(args[0]); err != nil {
-		// Command not found in PATH, try through shell for aliases
-		shell := os.Getenv("SHELL")
-		if shell == "" {
-			shell = "/bin/sh"
+	}
+	if atExit != nil {
+		if exitErr := atExit(s); exitErr != nil && err == nil {
+			err = exitErr
 		}
-		cmdStr := strings.Join(args, " ")
-		cmd = exec.Command(shell, "-ic", cmdStr)
-	} else {
-		cmd = exec.Command(args[0], args[1:]...)
 	}
+	return err
+}
 
-	// Start with PTY using our effective size
-	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{
-		Rows: uint16(effectiveHeight),
-		Cols: uint16(realWidth),
-	})
+// rememberSize stores the size chosen for a command in the state file
+func rememberSize(key string, sz longterm.SavedSize) error {
+	path := longterm.StatePath()
+	st, err := longterm.LoadState(path)
 	if err != nil {
-		return fmt.Errorf("failed to start pty: %w", err)
+		return err
 	}
-	defer ptmx.Close()
-
-	// Handle SIGWINCH (window resize)
-	signal.Notify(sigwinch, syscall.SIGWINCH)
-	go func() {
-		for range sigwinch {
-			if w, h, err := term.GetSize(int(os.Stdin.Fd())); err == nil {
-				// Determine which height to use based on toggle state
-				targetHeight := h
-				if !useRealSize.Load() {
-					// Use fake/delta height
-					delta := int(currentDelta.Load())
-					if delta != 0 {
-						targetHeight = h + delta
-						if targetHeight < 1 {
-							targetHeight = 1
-						}
-					} else {
-						targetHeight = int(currentHeight.Load())
-					}
-				}
-				// else: use real height (targetHeight already = h)
-
-				pty.Setsize(ptmx, &pty.Winsize{
-					Rows: uint16(targetHeight),
-					Cols: uint16(w),
-				})
-
-				// Refresh UI if in command mode (handles resize)
-				if Mode(currentMode.Load()) == ModeCommand {
-					triggerRefresh()
-				}
-			}
-		}
-	}()
-	// Trigger initial resize
-	sigwinch <- syscall.SIGWINCH
+	st.Sizes[key] = sz
+	return st.Save(path)
+}
 
-	// Display startup hint (before entering raw mode)
-	if term.IsTerminal(int(os.Stderr.Fd())) && ui.available {
-		fmt.Fprintf(os.Stderr, "%slong-term: Press Ctrl+\\ x3 for command mode%s\n", ansiGray, ansiReset)
+// writeExitReport writes the session's exit report as JSON to path
+func writeExitReport(s *longterm.Session, path string) error {
+	r, ok := s.Report()
+	if !ok {
+		return nil
 	}
-
-	// Put terminal into raw mode (only if stdin is a terminal)
-	var oldState *term.State
-	if term.IsTerminal(int(os.Stdin.Fd())) {
-		oldState, err = term.MakeRaw(int(os.Stdin.Fd()))
-		if err != nil {
-			return fmt.Errorf("failed to set raw mode: %w", err)
-		}
-		defer term.Restore(int(os.Stdin.Fd()), oldState)
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
 	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write exit report: %w", err)
+	}
+	return nil
+}
 
-	// Proxy I/O
-	// stdin -> pty (with magic key detection and keyboard parsing)
-	go func() {
-		magicDet := newMagicDetector(enterCommandChan)
-		// Chain: stdin -> magicDetector -> keyboardParser -> pty (if normal mode)
-		multiWriter := io.MultiWriter(magicDet, kbParser)
-		tee := io.TeeReader(os.Stdin, multiWriter)
-
-		// Copy to PTY, but check mode first
-		buf := make([]byte, 1024)
-		for {
-			n, err := tee.Read(buf)
-			if err != nil {
-				break
-			}
-
-			// Only forward to PTY if in normal mode
-			if Mode(currentMode.Load()) == ModeNormal {
-				ptmx.Write(buf[:n])
-			}
-			// In command mode, input is intercepted by keyboard parser
-		}
-	}()
-	// pty -> stdout
-	go func() {
-		io.Copy(os.Stdout, ptmx)
-	}()
-
-	// Wait for the command to finish
-	return cmd.Wait()
+// writeDump writes the final headless screen to path, or stdout if empty
+func writeDump(text, path string) error {
+	if path == "" {
+		_, err := os.Stdout.WriteString(text)
+		return err
+	}
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		return fmt.Errorf("failed to write dump: %w", err)
+	}
+	return nil
 }
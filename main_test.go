@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brandon-fryslie/long-term/longterm"
+)
+
+// TestOptionsPrecedence checks that the size comes from, lowest first, a
+// matching rule, the remembered size, -profile and explicit flags
+func TestOptionsPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.toml")
+	data := `
+[profile.tall]
+height = 5000
+
+[[rule]]
+command = "less"
+height = 300
+`
+	if err := os.WriteFile(config, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(longterm.ConfigEnv, config)
+	t.Setenv("XDG_STATE_HOME", dir)
+	st := &longterm.State{Sizes: map[string]longterm.SavedSize{
+		"less":        {Height: 700},
+		"less README": {Delta: 50},
+	}}
+	if err := st.Save(longterm.StatePath()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		flags string
+		args  []string
+		mode  longterm.SizeMode
+		value int
+	}{
+		{"", []string{"cat"}, longterm.SizeDelta, 2000},
+		{"", []string{"less"}, longterm.SizeAbsolute, 300},
+		{"-remember", []string{"cat"}, longterm.SizeDelta, 2000},
+		{"-remember", []string{"less"}, longterm.SizeAbsolute, 700},
+		{"-remember -remember-argv", []string{"less", "README"}, longterm.SizeDelta, 50},
+		{"-remember -remember-argv", []string{"less", "NEWS"}, longterm.SizeAbsolute, 300},
+		{"-profile tall", []string{"less"}, longterm.SizeAbsolute, 5000},
+		{"-remember -profile tall", []string{"less"}, longterm.SizeAbsolute, 5000},
+		{"-remember -delta 10", []string{"less"}, longterm.SizeDelta, 10},
+		{"-remember -profile tall -height 42", []string{"less"}, longterm.SizeAbsolute, 42},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("long-term", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		wf := newWrapperFlags(fs)
+		if err := fs.Parse(append(strings.Fields(tt.flags), "-no-socket")); err != nil {
+			t.Fatalf("%q: %v", tt.flags, err)
+		}
+		opts, err := wf.options(tt.args)
+		if err != nil {
+			t.Errorf("%q %q: %v", tt.flags, tt.args, err)
+			continue
+		}
+		if opts.Mode != tt.mode || opts.Value != tt.value {
+			t.Errorf("%q %q: mode %v value %d, want mode %v value %d",
+				tt.flags, tt.args, opts.Mode, opts.Value, tt.mode, tt.value)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/brandon-fryslie/long-term/longterm"
+)
+
+// runReplay implements `long-term replay`, which plays back a recording
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := fs.Float64("speed", 1, "playback speed multiplier")
+	idleLimit := fs.Duration("idle-limit", 0, "cap pauses between events at this duration (e.g. 1s)")
+	clamp := fs.Bool("clamp", false, "clamp cursor rows to the real window instead of replaying at the recorded height")
+	timing := fs.String("timing", "", "timing `FILE` for a raw typescript (from script -t or --log-timing)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s replay [flags] FILE\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Plays back an asciicast v2 recording or a raw typescript.\n")
+		fmt.Fprintf(os.Stderr, "Keys: space pause/resume, . step while paused, +/- speed, q or ESC quit.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *speed <= 0 {
+		fmt.Fprintf(os.Stderr, "long-term replay: speed must be positive\n")
+		return 2
+	}
+
+	rec, err := longterm.LoadRecording(fs.Arg(0), *timing)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "long-term replay: %v\n", err)
+		return 1
+	}
+
+	err = longterm.Replay(rec, longterm.ReplayOptions{
+		Speed:     *speed,
+		IdleLimit: *idleLimit,
+		Clamp:     *clamp,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "long-term replay: %v\n", err)
+		return 1
+	}
+	return 0
+}
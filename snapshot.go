@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/brandon-fryslie/long-term/longterm"
+)
+
+// runSnapshot implements `long-term snapshot`, which wraps a command like
+// the main mode and renders its final screen when it exits
+func runSnapshot(args []string) int {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	wf := newWrapperFlags(fs)
+	format := fs.String("format", longterm.FormatHTML, "snapshot format: html, svg or ansi")
+	output := fs.String("o", "", "write the snapshot to `FILE` instead of stdout (without it the command runs headless)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s snapshot [-format html|svg|ansi] [-o FILE] [flags] -- command [args...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Runs a command and renders its final screen, with colors, attributes and\n")
+		fmt.Fprintf(os.Stderr, "cursor, at the size long-term reported. Press 's' in command mode to\n")
+		fmt.Fprintf(os.Stderr, "take additional snapshots while it runs. Without -o the snapshot goes\n")
+		fmt.Fprintf(os.Stderr, "to stdout, so the command runs headless to keep its output out of it.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	rest := fs.Args()
+	if len(rest) == 0 {
+		fs.Usage()
+		return 2
+	}
+	switch *format {
+	case longterm.FormatHTML, longterm.FormatSVG, longterm.FormatANSI:
+	default:
+		fmt.Fprintf(os.Stderr, "long-term snapshot: unknown format %q\n", *format)
+		return 2
+	}
+
+	opts, err := wf.options(rest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "long-term snapshot: %v\n", err)
+		return 1
+	}
+	opts.SnapshotFormat = *format
+	opts.Snapshots = true
+
+	// Stdout is for the snapshot alone, so the child's output can't go
+	// there, and headless runs mustn't dump plain text to it either
+	if *output == "" {
+		opts.Headless = true
+		if *wf.dump == "" {
+			*wf.dump = os.DevNull
+		}
+	}
+
+	exit(run(rest, opts, wf, func(s *longterm.Session) error {
+		if *output == "" {
+			return s.Snapshot(os.Stdout, *format)
+		}
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+		defer f.Close()
+		return s.Snapshot(f, *format)
+	}))
+	return 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/brandon-fryslie/long-term/longterm"
+)
+
+// runState implements `long-term state`, which manages the sizes stored
+// by -remember
+func runState(args []string) int {
+	fs := flag.NewFlagSet("state", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s state list|clear [COMMAND...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Lists or clears the sizes remembered with -remember, stored in\n")
+		fmt.Fprintf(os.Stderr, "%s. clear without arguments forgets every command.\n", longterm.StatePath())
+	}
+	fs.Parse(args)
+
+	rest := fs.Args()
+	if len(rest) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	path := longterm.StatePath()
+	st, err := longterm.LoadState(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "long-term state: %v\n", err)
+		return 1
+	}
+
+	switch rest[0] {
+	case "list":
+		if len(rest) != 1 {
+			fs.Usage()
+			return 2
+		}
+		for _, key := range st.Keys() {
+			sz := st.Sizes[key]
+			fmt.Printf("%-24s %-28s %s\n", key, sz, sz.Updated.Local().Format("2006-01-02 15:04"))
+		}
+		return 0
+	case "clear":
+		if len(rest) == 1 {
+			st.Sizes = make(map[string]longterm.SavedSize)
+		}
+		for _, key := range rest[1:] {
+			if _, ok := st.Sizes[key]; !ok {
+				fmt.Fprintf(os.Stderr, "long-term state: no size remembered for %q\n", key)
+				return 1
+			}
+			delete(st.Sizes, key)
+		}
+		if err := st.Save(path); err != nil {
+			fmt.Fprintf(os.Stderr, "long-term state: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "long-term state: unknown command %q\n", rest[0])
+	return 2
+}